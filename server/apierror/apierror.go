@@ -0,0 +1,132 @@
+// Package apierror defines the body of every error response:
+//
+//	{"error": {"code": "not_found", "message": "banner 5 not found", "details": {"banner_id": 5}}}
+//
+// Code is stable and meant for programs, Message is for people and may change,
+// Details holds code-specific fields and is omitted when empty.
+package apierror
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+type Code string
+
+// The code catalogue. Each code is always returned with the same status.
+const (
+	// CodeInvalidRequest (400): malformed or missing parameters or body.
+	CodeInvalidRequest Code = "invalid_request"
+	// CodeUnauthorized (401): no token, or a static token no user has.
+	CodeUnauthorized Code = "unauthorized"
+	// CodeBearerTokenRequired (401): a static token was sent while only JWTs are accepted.
+	CodeBearerTokenRequired Code = "bearer_token_required"
+	// CodeTokenExpired (401): the JWT has expired.
+	CodeTokenExpired Code = "token_expired"
+	// CodeInvalidToken (401): the JWT is malformed, has an invalid signature or an unknown signing key.
+	CodeInvalidToken Code = "invalid_token"
+	// CodeForbidden (403): the user's role lacks a permission the operation requires.
+	CodeForbidden Code = "forbidden"
+	// CodeNotTagMember (403): the user may get banners only of the tags it is a member of.
+	CodeNotTagMember Code = "not_tag_member"
+	// CodeBannerInactive (403): the banner is turned off or outside its schedule.
+	CodeBannerInactive Code = "banner_inactive"
+	// CodeNotFound (404): no such banner, banner revision, job, role, user, token or tag membership.
+	CodeNotFound Code = "not_found"
+	// CodeBannerConflict (409): another banner already has one of the tag_ids with the feature_id.
+	CodeBannerConflict Code = "banner_conflict"
+	// CodeInternal (500): the storage failed or a handler panicked. The message is generic.
+	CodeInternal Code = "internal_error"
+	// CodeContractViolation (500): the response doesn't match the OpenAPI document,
+	// reported only with response validation, which is enabled in tests.
+	CodeContractViolation Code = "contract_violation"
+	// CodeUnavailable (503): the banner could be neither read from the cache nor loaded from the
+	// database. The message is generic.
+	CodeUnavailable Code = "unavailable"
+)
+
+// Error is an error response. The cause is logged with the request but not sent to
+// clients beyond what the message says.
+type Error struct {
+	Status  int                    `json:"-"`
+	Code    Code                   `json:"code"`
+	Message string                 `json:"message"`
+	Details map[string]interface{} `json:"details,omitempty"`
+
+	cause error
+}
+
+// genericMessages replace the messages of codes whose causes, such as storage errors or
+// panics, may reveal internals.
+var genericMessages = map[Code]string{
+	CodeInternal:    "internal error",
+	CodeUnavailable: "service temporarily unavailable",
+}
+
+// New returns an error with the message formatted as with fmt.Errorf, or a generic one
+// for CodeInternal and CodeUnavailable, which only get the formatted cause logged.
+func New(status int, code Code, format string, args ...interface{}) *Error {
+	err := fmt.Errorf(format, args...)
+	message, ok := genericMessages[code]
+	if !ok {
+		message = err.Error()
+	}
+	return &Error{Status: status, Code: code, Message: message, cause: err}
+}
+
+// BadRequest returns an error with CodeInvalidRequest.
+func BadRequest(format string, args ...interface{}) *Error {
+	return New(http.StatusBadRequest, CodeInvalidRequest, format, args...)
+}
+
+// NotFound returns an error with CodeNotFound.
+func NotFound(format string, args ...interface{}) *Error {
+	return New(http.StatusNotFound, CodeNotFound, format, args...)
+}
+
+// Internal returns an error with CodeInternal.
+func Internal(format string, args ...interface{}) *Error {
+	return New(http.StatusInternalServerError, CodeInternal, format, args...)
+}
+
+// With adds a detail to the error.
+func (e *Error) With(key string, value interface{}) *Error {
+	if e.Details == nil {
+		e.Details = make(map[string]interface{})
+	}
+	e.Details[key] = value
+	return e
+}
+
+// Error describes the cause, which is more detailed than the message for some codes.
+func (e *Error) Error() string {
+	if e.cause == nil {
+		return string(e.Code) + ": " + e.Message
+	}
+	return string(e.Code) + ": " + e.cause.Error()
+}
+
+func (e *Error) Unwrap() error {
+	return e.cause
+}
+
+// Respond aborts the request with the error and records it for the request log.
+func Respond(c *gin.Context, err *Error) {
+	_ = c.Error(err)
+	c.AbortWithStatusJSON(err.Status, gin.H{"error": err})
+}
+
+// Recovery answers requests whose handlers panicked with CodeInternal, logging the
+// panic value with the request.
+func Recovery() gin.HandlerFunc {
+	return gin.CustomRecovery(func(c *gin.Context, recovered interface{}) {
+		Respond(c, Internal("panic: %v", recovered))
+	})
+}
+
+// NoRoute answers requests matching no route with CodeNotFound.
+func NoRoute(c *gin.Context) {
+	Respond(c, NotFound("no route for %s %s", c.Request.Method, c.Request.URL.Path))
+}
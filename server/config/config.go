@@ -0,0 +1,358 @@
+// Package config loads the server settings from an optional YAML (or JSON) file
+// at CONFIG_FILE, then lets environment variables override them.
+package config
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"strconv"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+type Config struct {
+	Server   ServerConfig   `yaml:"server"`
+	Database DatabaseConfig `yaml:"database"`
+	Cache    CacheConfig    `yaml:"cache"`
+	Auth     AuthConfig     `yaml:"auth"`
+	Log      LogConfig      `yaml:"log"`
+	Tracing  TracingConfig  `yaml:"tracing"`
+}
+
+type ServerConfig struct {
+	// Addr is the address to listen on, LISTEN_ADDR or ":$PORT".
+	Addr string `yaml:"addr"`
+	// GinMode is one of debug, release or test, GIN_MODE.
+	GinMode      string        `yaml:"gin_mode"`
+	ReadTimeout  time.Duration `yaml:"read_timeout"`
+	WriteTimeout time.Duration `yaml:"write_timeout"`
+	IdleTimeout  time.Duration `yaml:"idle_timeout"`
+	// ShutdownTimeout limits how long in-flight requests are drained on SIGINT or SIGTERM.
+	ShutdownTimeout time.Duration `yaml:"shutdown_timeout"`
+	// UnreadyOnShutdown makes /readyz fail for ShutdownDelay before draining starts,
+	// giving load balancers time to stop sending requests.
+	UnreadyOnShutdown bool          `yaml:"unready_on_shutdown"`
+	ShutdownDelay     time.Duration `yaml:"shutdown_delay"`
+	// ValidateRequests rejects requests not matching the OpenAPI document before they reach handlers.
+	ValidateRequests bool `yaml:"validate_requests"`
+}
+
+type DatabaseConfig struct {
+	// URL is the Postgres connection string, DB_URL.
+	URL             string        `yaml:"url"`
+	MaxOpenConns    int           `yaml:"max_open_conns"`
+	MaxIdleConns    int           `yaml:"max_idle_conns"`
+	ConnMaxLifetime time.Duration `yaml:"conn_max_lifetime"`
+	ConnMaxIdleTime time.Duration `yaml:"conn_max_idle_time"`
+}
+
+type CacheConfig struct {
+	// Backend is memory, keeping entries in the process, or redis, sharing them
+	// between replicas through the server at RedisURL.
+	Backend  string `yaml:"backend"`
+	RedisURL string `yaml:"redis_url"`
+	// InvalidationChannel enables fanning banner, user and role invalidations out through
+	// Redis pub/sub.
+	InvalidationChannel string `yaml:"invalidation_channel"`
+
+	Banner BannerCacheConfig `yaml:"banner"`
+	User   MemoryCacheConfig `yaml:"user"`
+	Role   MemoryCacheConfig `yaml:"role"`
+}
+
+// MemoryCacheConfig sizes a cache. MaxEntries and MaxBytes only apply to the memory
+// backend, zero means no limit.
+type MemoryCacheConfig struct {
+	TTL        time.Duration `yaml:"ttl"`
+	MaxEntries int           `yaml:"max_entries"`
+	MaxBytes   int64         `yaml:"max_bytes"`
+}
+
+type BannerCacheConfig struct {
+	MemoryCacheConfig `yaml:",inline"`
+	// NotFoundTTL is how long a lookup that found no banner is cached.
+	NotFoundTTL time.Duration `yaml:"not_found_ttl"`
+	// StaleTTL is how long an expired banner is still served while it is refreshed.
+	StaleTTL time.Duration `yaml:"stale_ttl"`
+}
+
+// Authentication modes: static tokens, JWTs or both.
+const (
+	AuthModeToken = "token"
+	AuthModeJWT   = "jwt"
+	AuthModeBoth  = "both"
+)
+
+type AuthConfig struct {
+	Mode string `yaml:"mode"`
+	// JWTSecret verifies HS256 tokens, JWKSFile holds public keys verifying RS256 ones.
+	JWTSecret string `yaml:"jwt_hs256_secret"`
+	JWKSFile  string `yaml:"jwt_jwks_file"`
+}
+
+type LogConfig struct {
+	// Level is one of debug, info, warn or error.
+	Level string `yaml:"level"`
+}
+
+const (
+	TracingExporterNone   = "none"
+	TracingExporterStdout = "stdout"
+	TracingExporterOTLP   = "otlp"
+)
+
+type TracingConfig struct {
+	// Exporter is none, stdout or otlp. Incoming trace context is propagated even with none.
+	Exporter    string `yaml:"exporter"`
+	ServiceName string `yaml:"service_name"`
+	// OTLPEndpoint is the host:port of an OTLP/HTTP collector. When empty, the standard
+	// OTEL_EXPORTER_OTLP_* variables apply.
+	OTLPEndpoint string  `yaml:"otlp_endpoint"`
+	OTLPInsecure bool    `yaml:"otlp_insecure"`
+	SampleRatio  float64 `yaml:"sample_ratio"`
+}
+
+// Default returns the settings used when neither the file nor the environment set them.
+func Default() Config {
+	return Config{
+		Server: ServerConfig{
+			Addr:            ":8080",
+			GinMode:         "release",
+			ReadTimeout:     10 * time.Second,
+			WriteTimeout:    30 * time.Second,
+			IdleTimeout:     2 * time.Minute,
+			ShutdownTimeout: 20 * time.Second,
+			ShutdownDelay:   5 * time.Second,
+		},
+		Database: DatabaseConfig{
+			MaxOpenConns:    20,
+			MaxIdleConns:    10,
+			ConnMaxLifetime: time.Hour,
+			ConnMaxIdleTime: 10 * time.Minute,
+		},
+		Cache: CacheConfig{
+			Backend: "memory",
+			Banner: BannerCacheConfig{
+				MemoryCacheConfig: MemoryCacheConfig{TTL: 5 * time.Minute, MaxEntries: 10000, MaxBytes: 64 << 20},
+				NotFoundTTL:       30 * time.Second,
+			},
+			User: MemoryCacheConfig{TTL: time.Hour, MaxEntries: 10000, MaxBytes: 16 << 20},
+			Role: MemoryCacheConfig{TTL: time.Minute, MaxEntries: 1000},
+		},
+		Auth: AuthConfig{Mode: AuthModeBoth},
+		Log:  LogConfig{Level: "info"},
+		Tracing: TracingConfig{
+			Exporter:    TracingExporterNone,
+			ServiceName: "banner-service",
+			SampleRatio: 1,
+		},
+	}
+}
+
+// Load reads the file at CONFIG_FILE if it is set, applies environment overrides
+// and validates the result, reporting every invalid setting at once.
+func Load() (Config, error) {
+	cfg := Default()
+	if file := os.Getenv("CONFIG_FILE"); len(file) > 0 {
+		raw, err := os.ReadFile(file)
+		if err != nil {
+			return cfg, fmt.Errorf("error reading config file: %w", err)
+		}
+		decoder := yaml.NewDecoder(bytes.NewReader(raw))
+		decoder.KnownFields(true)
+		if err := decoder.Decode(&cfg); err != nil {
+			return cfg, fmt.Errorf("invalid config file %s: %w", file, err)
+		}
+	}
+
+	env := envOverrides{}
+	env.string("LISTEN_ADDR", &cfg.Server.Addr)
+	if port := os.Getenv("PORT"); len(port) > 0 && len(os.Getenv("LISTEN_ADDR")) == 0 {
+		cfg.Server.Addr = ":" + port
+	}
+	env.string("GIN_MODE", &cfg.Server.GinMode)
+	env.duration("SERVER_READ_TIMEOUT", &cfg.Server.ReadTimeout)
+	env.duration("SERVER_WRITE_TIMEOUT", &cfg.Server.WriteTimeout)
+	env.duration("SERVER_IDLE_TIMEOUT", &cfg.Server.IdleTimeout)
+	env.duration("SHUTDOWN_TIMEOUT", &cfg.Server.ShutdownTimeout)
+	env.bool("UNREADY_ON_SHUTDOWN", &cfg.Server.UnreadyOnShutdown)
+	env.duration("SHUTDOWN_DELAY", &cfg.Server.ShutdownDelay)
+	env.bool("VALIDATE_REQUESTS", &cfg.Server.ValidateRequests)
+
+	env.string("DB_URL", &cfg.Database.URL)
+	env.int("DB_MAX_OPEN_CONNS", &cfg.Database.MaxOpenConns)
+	env.int("DB_MAX_IDLE_CONNS", &cfg.Database.MaxIdleConns)
+	env.duration("DB_CONN_MAX_LIFETIME", &cfg.Database.ConnMaxLifetime)
+	env.duration("DB_CONN_MAX_IDLE_TIME", &cfg.Database.ConnMaxIdleTime)
+
+	env.string("CACHE_BACKEND", &cfg.Cache.Backend)
+	env.string("REDIS_URL", &cfg.Cache.RedisURL)
+	env.string("CACHE_INVALIDATION_CHANNEL", &cfg.Cache.InvalidationChannel)
+	env.duration("BANNER_CACHE_TTL", &cfg.Cache.Banner.TTL)
+	env.duration("BANNER_CACHE_NOT_FOUND_TTL", &cfg.Cache.Banner.NotFoundTTL)
+	env.duration("BANNER_CACHE_STALE_TTL", &cfg.Cache.Banner.StaleTTL)
+	env.int("BANNER_CACHE_MAX_ENTRIES", &cfg.Cache.Banner.MaxEntries)
+	env.int64("BANNER_CACHE_MAX_BYTES", &cfg.Cache.Banner.MaxBytes)
+	env.duration("USER_CACHE_TTL", &cfg.Cache.User.TTL)
+	env.int("USER_CACHE_MAX_ENTRIES", &cfg.Cache.User.MaxEntries)
+	env.int64("USER_CACHE_MAX_BYTES", &cfg.Cache.User.MaxBytes)
+	env.duration("ROLE_CACHE_TTL", &cfg.Cache.Role.TTL)
+	env.int("ROLE_CACHE_MAX_ENTRIES", &cfg.Cache.Role.MaxEntries)
+
+	env.string("AUTH_MODE", &cfg.Auth.Mode)
+	env.string("JWT_HS256_SECRET", &cfg.Auth.JWTSecret)
+	env.string("JWT_JWKS_FILE", &cfg.Auth.JWKSFile)
+
+	env.string("LOG_LEVEL", &cfg.Log.Level)
+
+	env.string("TRACING_EXPORTER", &cfg.Tracing.Exporter)
+	env.string("TRACING_SERVICE_NAME", &cfg.Tracing.ServiceName)
+	env.string("TRACING_OTLP_ENDPOINT", &cfg.Tracing.OTLPEndpoint)
+	env.bool("TRACING_OTLP_INSECURE", &cfg.Tracing.OTLPInsecure)
+	env.float64("TRACING_SAMPLE_RATIO", &cfg.Tracing.SampleRatio)
+
+	if err := errors.Join(append(env.errs, cfg.Validate())...); err != nil {
+		return cfg, fmt.Errorf("invalid config: %w", err)
+	}
+	return cfg, nil
+}
+
+// Validate returns all the problems with the settings joined in one error.
+func (cfg *Config) Validate() error {
+	var errs []error
+	check := func(ok bool, format string, args ...interface{}) {
+		if !ok {
+			errs = append(errs, fmt.Errorf(format, args...))
+		}
+	}
+
+	check(len(cfg.Server.Addr) > 0, "server.addr is required")
+	check(oneOf(cfg.Server.GinMode, "debug", "release", "test"), "server.gin_mode should be debug, release or test, got %q", cfg.Server.GinMode)
+	check(cfg.Server.ReadTimeout > 0, "server.read_timeout should be positive")
+	check(cfg.Server.WriteTimeout > 0, "server.write_timeout should be positive")
+	check(cfg.Server.IdleTimeout > 0, "server.idle_timeout should be positive")
+	check(cfg.Server.ShutdownTimeout > 0, "server.shutdown_timeout should be positive")
+	check(cfg.Server.ShutdownDelay >= 0, "server.shutdown_delay should not be negative")
+
+	check(cfg.Database.MaxOpenConns >= 0, "database.max_open_conns should not be negative")
+	check(cfg.Database.MaxIdleConns >= 0, "database.max_idle_conns should not be negative")
+	check(cfg.Database.MaxOpenConns == 0 || cfg.Database.MaxIdleConns <= cfg.Database.MaxOpenConns,
+		"database.max_idle_conns should not exceed database.max_open_conns")
+	check(cfg.Database.ConnMaxLifetime >= 0, "database.conn_max_lifetime should not be negative")
+	check(cfg.Database.ConnMaxIdleTime >= 0, "database.conn_max_idle_time should not be negative")
+
+	check(oneOf(cfg.Cache.Backend, "memory", "redis"), "cache.backend should be memory or redis, got %q", cfg.Cache.Backend)
+	check(cfg.Cache.Backend != "redis" || len(cfg.Cache.RedisURL) > 0, "cache.redis_url is required for the redis backend")
+	check(len(cfg.Cache.InvalidationChannel) == 0 || len(cfg.Cache.RedisURL) > 0, "cache.redis_url is required for cache.invalidation_channel")
+	caches := []struct {
+		name  string
+		cache MemoryCacheConfig
+	}{{"banner", cfg.Cache.Banner.MemoryCacheConfig}, {"user", cfg.Cache.User}, {"role", cfg.Cache.Role}}
+	for _, c := range caches {
+		check(c.cache.TTL > 0, "cache.%s.ttl should be positive", c.name)
+		check(c.cache.MaxEntries >= 0, "cache.%s.max_entries should not be negative", c.name)
+		check(c.cache.MaxBytes >= 0, "cache.%s.max_bytes should not be negative", c.name)
+	}
+	check(cfg.Cache.Banner.NotFoundTTL > 0, "cache.banner.not_found_ttl should be positive")
+	check(cfg.Cache.Banner.StaleTTL >= 0, "cache.banner.stale_ttl should not be negative")
+
+	check(oneOf(cfg.Auth.Mode, AuthModeToken, AuthModeJWT, AuthModeBoth), "auth.mode should be token, jwt or both, got %q", cfg.Auth.Mode)
+	check(cfg.Auth.Mode != AuthModeJWT || len(cfg.Auth.JWTSecret) > 0 || len(cfg.Auth.JWKSFile) > 0,
+		"auth.jwt_hs256_secret or auth.jwt_jwks_file is required for the jwt auth mode")
+
+	var level slog.Level
+	check(level.UnmarshalText([]byte(cfg.Log.Level)) == nil, "log.level should be debug, info, warn or error, got %q", cfg.Log.Level)
+
+	check(oneOf(cfg.Tracing.Exporter, TracingExporterNone, TracingExporterStdout, TracingExporterOTLP),
+		"tracing.exporter should be none, stdout or otlp, got %q", cfg.Tracing.Exporter)
+	check(len(cfg.Tracing.ServiceName) > 0, "tracing.service_name is required")
+	check(cfg.Tracing.SampleRatio >= 0 && cfg.Tracing.SampleRatio <= 1, "tracing.sample_ratio should be between 0 and 1")
+
+	return errors.Join(errs...)
+}
+
+// JWTEnabled reports whether requests may authenticate with JWTs.
+func (a *AuthConfig) JWTEnabled() bool {
+	return a.Mode != AuthModeToken && (len(a.JWTSecret) > 0 || len(a.JWKSFile) > 0)
+}
+
+// LogLevel returns the parsed Level, which Validate has checked.
+func (l *LogConfig) LogLevel() slog.Level {
+	var level slog.Level
+	_ = level.UnmarshalText([]byte(l.Level))
+	return level
+}
+
+func oneOf(value string, allowed ...string) bool {
+	for _, a := range allowed {
+		if value == a {
+			return true
+		}
+	}
+	return false
+}
+
+// envOverrides sets values from environment variables, collecting parse errors.
+type envOverrides struct {
+	errs []error
+}
+
+func (e *envOverrides) string(name string, dst *string) {
+	if value, ok := os.LookupEnv(name); ok && len(value) > 0 {
+		*dst = value
+	}
+}
+
+func (e *envOverrides) bool(name string, dst *bool) {
+	e.parse(name, func(value string) (err error) {
+		*dst, err = strconv.ParseBool(value)
+		return err
+	})
+}
+
+func (e *envOverrides) int(name string, dst *int) {
+	var n int64
+	if e.parse(name, func(value string) (err error) {
+		n, err = strconv.ParseInt(value, 10, 0)
+		return err
+	}) {
+		*dst = int(n)
+	}
+}
+
+func (e *envOverrides) int64(name string, dst *int64) {
+	e.parse(name, func(value string) (err error) {
+		*dst, err = strconv.ParseInt(value, 10, 64)
+		return err
+	})
+}
+
+func (e *envOverrides) float64(name string, dst *float64) {
+	e.parse(name, func(value string) (err error) {
+		*dst, err = strconv.ParseFloat(value, 64)
+		return err
+	})
+}
+
+func (e *envOverrides) duration(name string, dst *time.Duration) {
+	e.parse(name, func(value string) (err error) {
+		*dst, err = time.ParseDuration(value)
+		return err
+	})
+}
+
+func (e *envOverrides) parse(name string, parse func(value string) error) bool {
+	value := os.Getenv(name)
+	if len(value) == 0 {
+		return false
+	}
+	if err := parse(value); err != nil {
+		e.errs = append(e.errs, fmt.Errorf("invalid %s: %w", name, err))
+		return false
+	}
+	return true
+}
@@ -0,0 +1,103 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+	"log"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+
+	"server/db"
+	"server/repository"
+	"server/schemas"
+	"server/tracing"
+)
+
+type bannerLookupStatus int
+
+const (
+	bannerFound bannerLookupStatus = iota
+	bannerNotFound
+	bannerLookupFailed
+)
+
+// bannerLookup is the outcome of looking up a user banner. Err is set only for
+// bannerLookupFailed, which is never cached.
+type bannerLookup struct {
+	Status bannerLookupStatus
+	Banner schemas.Banner
+	Err    error
+}
+
+// lookupUserBanner returns the banner for the tag and feature, from the cache unless
+// useLastRevision is set. An entry past its refresh time is still returned while
+// a single background refresh runs.
+func (h *Handler) lookupUserBanner(ctx context.Context, tagId int, featureId int, useLastRevision bool) bannerLookup {
+	ctx, span := tracing.Tracer().Start(ctx, "lookupUserBanner", trace.WithAttributes(
+		attribute.Int("banner.tag_id", tagId),
+		attribute.Int("banner.feature_id", featureId),
+		attribute.Bool("banner.use_last_revision", useLastRevision),
+	))
+	defer span.End()
+
+	key := db.BannerCacheKey(int64(tagId), featureId)
+	if !useLastRevision {
+		var entry db.BannerCacheEntry
+		found, err := h.BannerCache.Get(ctx, key, &entry)
+		span.SetAttributes(attribute.Bool("banner.cache_hit", found))
+		if err != nil {
+			// The cache is only an optimization, so the banner is looked up in the database instead.
+			log.Printf("error reading banner cache: %v", err)
+			span.RecordError(err)
+		} else if found {
+			if db.BannerCacheStaleTTL > 0 && time.Now().After(entry.RefreshAt) {
+				span.SetAttributes(attribute.Bool("banner.cache_stale", true))
+				h.bannerLookups.DoChan(key, func() (interface{}, error) {
+					return h.fetchUserBanner(ctx, key, tagId, featureId), nil
+				})
+			}
+			if entry.Banner == nil {
+				return bannerLookup{Status: bannerNotFound}
+			}
+			return bannerLookup{Status: bannerFound, Banner: *entry.Banner}
+		}
+	}
+
+	v, _, shared := h.bannerLookups.Do(key, func() (interface{}, error) {
+		return h.fetchUserBanner(ctx, key, tagId, featureId), nil
+	})
+	span.SetAttributes(attribute.Bool("banner.lookup_shared", shared))
+	return v.(bannerLookup)
+}
+
+// fetchUserBanner loads the banner from the database and caches it unless the query failed
+// or a write invalidated the key meanwhile, as the result may then be outdated.
+// It is not cancelled with the request as its result may be shared by several requests,
+// the context only carries the trace of the request that started it.
+func (h *Handler) fetchUserBanner(ctx context.Context, key string, tagId int, featureId int) bannerLookup {
+	ctx = context.WithoutCancel(ctx)
+	generation := db.BannerGeneration(key)
+	banner, err := h.Banners.FindForUser(ctx, tagId, featureId)
+
+	var lookup bannerLookup
+	var entry db.BannerCacheEntry
+	var ttl time.Duration
+	if errors.Is(err, repository.ErrNotFound) {
+		lookup = bannerLookup{Status: bannerNotFound}
+		ttl = db.BannerCacheNotFoundTTL
+	} else if err != nil {
+		return bannerLookup{Status: bannerLookupFailed, Err: err}
+	} else {
+		lookup = bannerLookup{Status: bannerFound, Banner: *banner}
+		entry.Banner = banner
+		ttl = db.BannerCacheTTL
+	}
+
+	entry.RefreshAt = time.Now().Add(ttl)
+	if _, err = db.SetBannerIfCurrent(ctx, h.BannerCache, key, generation, entry, ttl+db.BannerCacheStaleTTL); err != nil {
+		log.Printf("error writing banner cache: %v", err)
+	}
+	return lookup
+}
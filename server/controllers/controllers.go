@@ -2,17 +2,40 @@ package controllers
 
 import (
 	"errors"
-	"fmt"
+	"log"
 	"net/http"
+	"reflect"
 	"strconv"
+	"time"
 
 	"github.com/gin-gonic/gin"
-	"github.com/patrickmn/go-cache"
+	"golang.org/x/sync/singleflight"
 
+	"server/apierror"
 	"server/db"
+	"server/health"
+	"server/jobs"
+	"server/middlewares"
+	"server/repository"
 	"server/schemas"
 )
 
+// Handler serves the banner API on top of the injected storage.
+type Handler struct {
+	Banners     repository.BannerRepository
+	Users       repository.UserRepository
+	Jobs        repository.JobRepository
+	Roles       repository.RoleRepository
+	Worker      *jobs.Worker
+	BannerCache db.Cache
+	UserCache   db.Cache
+	RoleCache   db.Cache
+	Health      *health.Checker
+
+	// bannerLookups makes concurrent cache misses for the same key share one query.
+	bannerLookups singleflight.Group
+}
+
 func parseQueries(c *gin.Context) (tagId int, featureId int, useLastRevision bool, limit int, offset int, err error) {
 	tagQuery := c.Query("tag_id")
 	if len(tagQuery) > 0 {
@@ -44,8 +67,8 @@ func parseQueries(c *gin.Context) (tagId int, featureId int, useLastRevision boo
 	limitQuery := c.Query("limit")
 	if len(limitQuery) > 0 {
 		limit, err = strconv.Atoi(limitQuery)
-		if err != nil {
-			err = errors.New("invalid limit: must be integer")
+		if err != nil || limit < 0 {
+			err = errors.New("invalid limit: must be non-negative integer")
 			return
 		}
 	}
@@ -53,8 +76,8 @@ func parseQueries(c *gin.Context) (tagId int, featureId int, useLastRevision boo
 	offsetQuery := c.Query("offset")
 	if len(offsetQuery) > 0 {
 		offset, err = strconv.Atoi(offsetQuery)
-		if err != nil {
-			err = errors.New("invalid offset: must be integer")
+		if err != nil || offset < 0 {
+			err = errors.New("invalid offset: must be non-negative integer")
 			return
 		}
 	}
@@ -62,140 +85,223 @@ func parseQueries(c *gin.Context) (tagId int, featureId int, useLastRevision boo
 	return
 }
 
-func GetUserBanner(c *gin.Context) {
+func parseActiveAt(c *gin.Context) (*time.Time, error) {
+	activeAtQuery := c.Query("active_at")
+	if len(activeAtQuery) == 0 {
+		return nil, nil
+	}
+
+	activeAt, err := time.Parse(time.RFC3339, activeAtQuery)
+	if err != nil {
+		return nil, errors.New("invalid active_at: must be RFC 3339 timestamp")
+	}
+	return &activeAt, nil
+}
+
+func validateSchedule(banner *schemas.Banner) error {
+	if banner.StartsAt != nil && banner.EndsAt != nil && !banner.EndsAt.After(*banner.StartsAt) {
+		return errors.New("ends_at should be after starts_at")
+	}
+	return nil
+}
+
+func (h *Handler) GetUserBanner(c *gin.Context) {
 	tagId, featureId, useLastRevision, _, _, err := parseQueries(c)
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Errorf("error parsing query params: %w", err).Error()})
+		apierror.Respond(c, apierror.BadRequest("error parsing query params: %w", err))
 		return
 	}
 
 	if tagId == 0 || featureId == 0 {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "tag_id and feature_id are required"})
+		apierror.Respond(c, apierror.BadRequest("tag_id and feature_id are required"))
 		return
 	}
 
-	cacheEntryKey := fmt.Sprintf("%d,%d", tagId, featureId)
-	var banner schemas.Banner
-	if v, ok := db.BannerCache.Get(cacheEntryKey); ok && !useLastRevision {
-		banner, ok = v.(schemas.Banner)
-		if !ok {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "invalid banner cache entry"})
-			return
-		}
-	} else {
-		db.DB.Model(&schemas.Banner{}).Where("tag_ids @> ARRAY[?]::integer[] AND feature_id = ?", tagId, featureId).First(&banner)
+	// Users who may read all banners aren't limited to the tags they are members of.
+	user := c.MustGet(middlewares.UserKey).(schemas.User)
+	if !user.IsMemberOf(int64(tagId)) && !middlewares.HasPermission(c, schemas.PermissionReadBanners) {
+		apierror.Respond(c, apierror.New(http.StatusForbidden, apierror.CodeNotTagMember, "user is not a member of tag %d", tagId).
+			With("tag_id", tagId))
+		return
 	}
 
-	db.BannerCache.Set(cacheEntryKey, banner, cache.DefaultExpiration)
-	if banner.ID == 0 {
-		c.Status(http.StatusNotFound)
-	} else if !banner.IsActive {
-		c.Status(http.StatusForbidden)
+	lookup := h.lookupUserBanner(c.Request.Context(), tagId, featureId, useLastRevision)
+	banner := lookup.Banner
+
+	// The schedule is checked on every request rather than baked into the cache entry,
+	// so a cached banner starts and stops being served exactly at its window boundaries.
+	if lookup.Status == bannerLookupFailed {
+		apierror.Respond(c, apierror.New(http.StatusServiceUnavailable, apierror.CodeUnavailable, "error getting banner from database: %w", lookup.Err))
+	} else if lookup.Status == bannerNotFound {
+		apierror.Respond(c, apierror.NotFound("no banner for tag %d and feature %d", tagId, featureId).
+			With("tag_id", tagId).With("feature_id", featureId))
+	} else if !banner.IsActive || !banner.IsScheduledAt(time.Now()) {
+		apierror.Respond(c, apierror.New(http.StatusForbidden, apierror.CodeBannerInactive, "banner is inactive or outside its schedule"))
 	} else {
 		c.JSON(http.StatusOK, banner.Content)
 	}
 }
 
-func GetBanners(c *gin.Context) {
+func (h *Handler) GetBanners(c *gin.Context) {
 	tagId, featureId, _, limit, offset, err := parseQueries(c)
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Errorf("error parsing query params: %w", err).Error()})
+		apierror.Respond(c, apierror.BadRequest("error parsing query params: %w", err))
 		return
 	}
-
-	if tagId == 0 && featureId == 0 {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "tag_id or feature_id is required"})
+	activeAt, err := parseActiveAt(c)
+	if err != nil {
+		apierror.Respond(c, apierror.BadRequest("error parsing query params: %w", err))
 		return
 	}
 
-	var banners []schemas.Banner
-	dbQuery := db.DB.Model(&schemas.Banner{})
-
-	if featureId != 0 {
-		dbQuery = dbQuery.Where("feature_id = ?", featureId)
-	}
-	if tagId != 0 {
-		dbQuery = dbQuery.Where("tag_ids @> ARRAY[?]::integer[]", int64(tagId))
-	}
-	if limit != 0 {
-		dbQuery = dbQuery.Limit(limit)
-	}
-	if offset != 0 {
-		dbQuery = dbQuery.Offset(offset)
+	if tagId == 0 && featureId == 0 && activeAt == nil {
+		apierror.Respond(c, apierror.BadRequest("tag_id, feature_id or active_at is required"))
+		return
 	}
 
-	if err = dbQuery.Find(&banners).Error; err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Errorf("error getting banners from database: %w", err).Error()})
+	filter := repository.BannerFilter{TagID: tagId, FeatureID: featureId, ActiveAt: activeAt, Limit: limit, Offset: offset}
+	banners, err := h.Banners.List(c.Request.Context(), filter)
+	if err != nil {
+		apierror.Respond(c, apierror.Internal("error getting banners from database: %w", err))
 	} else {
 		c.JSON(http.StatusOK, banners)
 	}
 }
 
-func PostBanner(c *gin.Context) {
+func (h *Handler) PostBanner(c *gin.Context) {
 	var banner schemas.Banner
 	if err := c.BindJSON(&banner); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Errorf("invalid banner body: %w", err).Error()})
+		apierror.Respond(c, apierror.BadRequest("invalid banner body: %w", err))
 		return
 	}
 	if banner.FeatureID == 0 || len(banner.TagIDs) == 0 {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "feature_id and tag_ids should be non-empty"})
+		apierror.Respond(c, apierror.BadRequest("feature_id and tag_ids should be non-empty"))
+		return
+	}
+	if err := validateSchedule(&banner); err != nil {
+		apierror.Respond(c, apierror.BadRequest("invalid banner body: %w", err))
+		return
 	}
 
-	res := db.DB.Model(&schemas.Banner{}).Create(&banner)
-	if res.Error != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Errorf("error creating banner in database: %w", res.Error).Error()})
+	err := h.Banners.Create(c.Request.Context(), &banner)
+	if err != nil {
+		respondBannerWriteError(c, "error creating banner in database", err)
 	} else {
+		h.invalidateBanners(c, &banner)
 		c.JSON(http.StatusCreated, gin.H{"banner_id": banner.ID})
 	}
 }
 
-func findBannerById(c *gin.Context) *schemas.Banner {
+func (h *Handler) findBannerById(c *gin.Context) *schemas.Banner {
 	idParam := c.Param("id")
 	id, err := strconv.Atoi(idParam)
-	if err != nil || id == 0 {
-		c.JSON(http.StatusBadRequest, fmt.Errorf("invalid banner id: %w", err).Error())
+	if err != nil || id <= 0 {
+		apierror.Respond(c, apierror.BadRequest("invalid banner id: must be positive integer"))
 		return nil
 	}
 
-	var banner schemas.Banner
-	if err = db.DB.Model(&schemas.Banner{}).First(&banner, id).Error; err != nil {
-		c.Status(http.StatusNotFound)
+	banner, err := h.Banners.FindByID(c.Request.Context(), uint(id))
+	if errors.Is(err, repository.ErrNotFound) {
+		apierror.Respond(c, apierror.NotFound("banner %d not found", id).With("banner_id", id))
+		return nil
+	} else if err != nil {
+		apierror.Respond(c, apierror.Internal("error getting banner from database: %w", err))
 		return nil
 	}
 
-	return &banner
+	return banner
+}
+
+// invalidateBanners evicts cached user banners affected by a write. Errors are only
+// logged as the write itself has already succeeded.
+func (h *Handler) invalidateBanners(c *gin.Context, banners ...*schemas.Banner) {
+	if err := db.InvalidateBanners(c.Request.Context(), h.BannerCache, banners...); err != nil {
+		log.Printf("error invalidating banner cache: %v", err)
+	}
 }
 
-func UpdateBanner(c *gin.Context) {
-	banner := findBannerById(c)
+// sameBannerContent reports whether banners differ in nothing but activity.
+func sameBannerContent(a *schemas.Banner, b *schemas.Banner) bool {
+	return a.FeatureID == b.FeatureID &&
+		reflect.DeepEqual([]int64(a.TagIDs), []int64(b.TagIDs)) &&
+		reflect.DeepEqual(a.Content, b.Content) &&
+		sameTime(a.StartsAt, b.StartsAt) &&
+		sameTime(a.EndsAt, b.EndsAt)
+}
+
+func sameTime(a *time.Time, b *time.Time) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return a.Equal(*b)
+}
+
+func (h *Handler) UpdateBanner(c *gin.Context) {
+	banner := h.findBannerById(c)
 	if banner == nil {
 		return
 	}
 
-	if err := c.BindJSON(&banner); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Errorf("invalid banner body: %w", err).Error()})
+	// The body is decoded into the banner itself rather than the pointer, so that a null
+	// body leaves it unchanged instead of setting it to nil.
+	previous := banner.Clone()
+	if err := c.BindJSON(banner); err != nil {
+		apierror.Respond(c, apierror.BadRequest("invalid banner body: %w", err))
 		return
 	}
+	banner.ID = previous.ID
 
-	if err := db.DB.Save(banner).Error; err != nil {
-		c.JSON(http.StatusInternalServerError, fmt.Errorf("error saving banner to database: %w", err).Error())
+	if !authorizeBannerChange(c, &previous, banner) {
+		return
+	}
+	if banner.FeatureID == 0 || len(banner.TagIDs) == 0 {
+		apierror.Respond(c, apierror.BadRequest("feature_id and tag_ids should be non-empty"))
+		return
+	}
+	if err := validateSchedule(banner); err != nil {
+		apierror.Respond(c, apierror.BadRequest("invalid banner body: %w", err))
+		return
+	}
+
+	err := h.Banners.Update(c.Request.Context(), banner)
+	if err != nil {
+		respondBannerWriteError(c, "error saving banner to database", err)
 		return
 	} else {
+		h.invalidateBanners(c, &previous, banner)
 		c.Status(http.StatusOK)
 	}
 }
 
-func DeleteBanner(c *gin.Context) {
-	banner := findBannerById(c)
+// authorizeBannerChange checks that the user may change previous into banner, answering
+// 403 otherwise. The routes changing banners let through users who may either edit or
+// toggle banners, here it is checked that the user may make this particular change.
+func authorizeBannerChange(c *gin.Context, previous *schemas.Banner, banner *schemas.Banner) bool {
+	if banner.IsActive != previous.IsActive && !middlewares.HasPermission(c, schemas.PermissionToggleBanners) {
+		apierror.Respond(c, apierror.New(http.StatusForbidden, apierror.CodeForbidden, "changing is_active requires %s permission", schemas.PermissionToggleBanners).
+			With("required_permissions", []schemas.Permission{schemas.PermissionToggleBanners}))
+		return false
+	}
+	if !sameBannerContent(previous, banner) && !middlewares.HasPermission(c, schemas.PermissionEditBanners) {
+		apierror.Respond(c, apierror.New(http.StatusForbidden, apierror.CodeForbidden, "changing banner requires %s permission", schemas.PermissionEditBanners).
+			With("required_permissions", []schemas.Permission{schemas.PermissionEditBanners}))
+		return false
+	}
+	return true
+}
+
+func (h *Handler) DeleteBanner(c *gin.Context) {
+	banner := h.findBannerById(c)
 	if banner == nil {
 		return
 	}
 
-	if err := db.DB.Delete(banner).Error; err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Errorf("error deleting banner from database: %w", err).Error()})
+	err := h.Banners.Delete(c.Request.Context(), banner.ID)
+	if err != nil {
+		apierror.Respond(c, apierror.Internal("error deleting banner from database: %w", err))
 		return
 	} else {
+		h.invalidateBanners(c, banner)
 		c.Status(http.StatusNoContent)
 	}
-
 }
@@ -0,0 +1,24 @@
+package controllers
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+
+	"server/apierror"
+	"server/repository"
+)
+
+// respondBannerWriteError reports err from a banner write, answering 409 on conflicts.
+func respondBannerWriteError(c *gin.Context, message string, err error) {
+	var conflict *repository.ConflictError
+	if errors.As(err, &conflict) {
+		apierror.Respond(c, apierror.New(http.StatusConflict, apierror.CodeBannerConflict, "%w", conflict).
+			With("conflicting_banner_id", conflict.BannerID).
+			With("tag_id", conflict.TagID).
+			With("feature_id", conflict.FeatureID))
+	} else {
+		apierror.Respond(c, apierror.Internal("%s: %w", message, err))
+	}
+}
@@ -0,0 +1,32 @@
+package controllers
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"server/health"
+)
+
+// readinessTimeout bounds all readiness checks of one request.
+const readinessTimeout = 3 * time.Second
+
+// Healthz reports that the process is alive without checking its dependencies.
+func Healthz(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{"status": health.StatusOK})
+}
+
+// Readyz reports whether the server can serve requests, with the result of every check.
+func (h *Handler) Readyz(c *gin.Context) {
+	ctx, cancel := context.WithTimeout(c.Request.Context(), readinessTimeout)
+	defer cancel()
+
+	status, checks := h.Health.Ready(ctx)
+	code := http.StatusOK
+	if status != health.StatusOK {
+		code = http.StatusServiceUnavailable
+	}
+	c.JSON(code, gin.H{"status": status, "checks": checks})
+}
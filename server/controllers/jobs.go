@@ -0,0 +1,50 @@
+package controllers
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+
+	"server/apierror"
+	"server/repository"
+)
+
+func (h *Handler) DeleteBanners(c *gin.Context) {
+	tagId, featureId, _, _, _, err := parseQueries(c)
+	if err != nil {
+		apierror.Respond(c, apierror.BadRequest("error parsing query params: %w", err))
+		return
+	}
+
+	if tagId == 0 && featureId == 0 {
+		apierror.Respond(c, apierror.BadRequest("tag_id or feature_id is required"))
+		return
+	}
+
+	job, err := h.Worker.EnqueueBannerDeletion(c.Request.Context(), featureId, tagId)
+	if err != nil {
+		apierror.Respond(c, apierror.Internal("error creating deletion job: %w", err))
+	} else {
+		c.JSON(http.StatusAccepted, gin.H{"job_id": job.ID})
+	}
+}
+
+func (h *Handler) GetJob(c *gin.Context) {
+	idParam := c.Param("id")
+	id, err := strconv.Atoi(idParam)
+	if err != nil || id <= 0 {
+		apierror.Respond(c, apierror.BadRequest("invalid job id: must be positive integer"))
+		return
+	}
+
+	job, err := h.Jobs.FindByID(c.Request.Context(), uint(id))
+	if errors.Is(err, repository.ErrNotFound) {
+		apierror.Respond(c, apierror.NotFound("job %d not found", id).With("job_id", id))
+	} else if err != nil {
+		apierror.Respond(c, apierror.Internal("error getting job from database: %w", err))
+	} else {
+		c.JSON(http.StatusOK, job)
+	}
+}
@@ -0,0 +1,72 @@
+package controllers
+
+import (
+	"errors"
+	"log"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/lib/pq"
+
+	"server/apierror"
+	"server/db"
+	"server/repository"
+	"server/schemas"
+)
+
+type roleRequest struct {
+	Permissions []schemas.Permission `json:"permissions"`
+}
+
+func (h *Handler) GetRoles(c *gin.Context) {
+	roles, err := h.Roles.List(c.Request.Context())
+	if err != nil {
+		apierror.Respond(c, apierror.Internal("error getting roles from database: %w", err))
+	} else {
+		c.JSON(http.StatusOK, roles)
+	}
+}
+
+// PutRole creates the role or replaces its permissions.
+func (h *Handler) PutRole(c *gin.Context) {
+	var request roleRequest
+	if err := c.BindJSON(&request); err != nil {
+		apierror.Respond(c, apierror.BadRequest("invalid role body: %w", err))
+		return
+	}
+
+	role := schemas.Role{Name: c.Param("name"), Permissions: pq.StringArray{}}
+	for _, permission := range request.Permissions {
+		if !schemas.IsKnownPermission(permission) {
+			apierror.Respond(c, apierror.BadRequest("unknown permission %q", permission).With("permission", permission))
+			return
+		}
+		role.Permissions = append(role.Permissions, string(permission))
+	}
+
+	if err := h.Roles.Save(c.Request.Context(), &role); err != nil {
+		apierror.Respond(c, apierror.Internal("error saving role to database: %w", err))
+		return
+	}
+	h.evictRole(c, role.Name)
+	c.JSON(http.StatusOK, role)
+}
+
+func (h *Handler) DeleteRole(c *gin.Context) {
+	name := c.Param("name")
+	err := h.Roles.Delete(c.Request.Context(), name)
+	if errors.Is(err, repository.ErrNotFound) {
+		apierror.Respond(c, apierror.NotFound("role %q not found", name).With("role", name))
+	} else if err != nil {
+		apierror.Respond(c, apierror.Internal("error deleting role from database: %w", err))
+	} else {
+		h.evictRole(c, name)
+		c.Status(http.StatusNoContent)
+	}
+}
+
+func (h *Handler) evictRole(c *gin.Context, name string) {
+	if err := db.InvalidateRoles(c.Request.Context(), h.RoleCache, name); err != nil {
+		log.Printf("error evicting role cache entry: %v", err)
+	}
+}
@@ -0,0 +1,254 @@
+package controllers
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+
+	"server/apierror"
+	"server/db"
+	"server/repository"
+	"server/schemas"
+)
+
+type userRequest struct {
+	Role   string  `json:"role"`
+	TagIDs []int64 `json:"tag_ids"`
+}
+
+type roleNameRequest struct {
+	Role string `json:"role"`
+}
+
+// newToken returns a random static token and its schemas.HashToken.
+func newToken() (token string, tokenHash string, err error) {
+	buf := make([]byte, 32)
+	if _, err = rand.Read(buf); err != nil {
+		return "", "", err
+	}
+	token = hex.EncodeToString(buf)
+	return token, schemas.HashToken(token), nil
+}
+
+func (h *Handler) GetUsers(c *gin.Context) {
+	_, _, _, limit, offset, err := parseQueries(c)
+	if err != nil {
+		apierror.Respond(c, apierror.BadRequest("error parsing query params: %w", err))
+		return
+	}
+
+	users, err := h.Users.List(c.Request.Context(), limit, offset)
+	if err != nil {
+		apierror.Respond(c, apierror.Internal("error getting users from database: %w", err))
+	} else {
+		c.JSON(http.StatusOK, users)
+	}
+}
+
+// PostUser creates a user and issues its token. The token is returned only in this
+// response, as just its hash is stored.
+func (h *Handler) PostUser(c *gin.Context) {
+	var request userRequest
+	if err := c.BindJSON(&request); err != nil {
+		apierror.Respond(c, apierror.BadRequest("invalid user body: %w", err))
+		return
+	}
+	if !h.checkRoleExists(c, request.Role) {
+		return
+	}
+
+	token, tokenHash, err := newToken()
+	if err != nil {
+		apierror.Respond(c, apierror.Internal("error generating token: %w", err))
+		return
+	}
+	user := schemas.User{TokenHash: tokenHash, Role: request.Role, TagIDs: request.TagIDs}
+	if err := h.Users.Create(c.Request.Context(), &user); err != nil {
+		apierror.Respond(c, apierror.Internal("error creating user in database: %w", err))
+		return
+	}
+	c.JSON(http.StatusCreated, gin.H{"user_id": user.ID, "token": token})
+}
+
+func (h *Handler) PutUserRole(c *gin.Context) {
+	user := h.findUserById(c)
+	if user == nil {
+		return
+	}
+	var request roleNameRequest
+	if err := c.BindJSON(&request); err != nil {
+		apierror.Respond(c, apierror.BadRequest("invalid role body: %w", err))
+		return
+	}
+	if !h.checkRoleExists(c, request.Role) {
+		return
+	}
+
+	user.Role = request.Role
+	h.updateUser(c, user, user.TokenHash, http.StatusOK, user)
+}
+
+// PostUserToken issues a new token for the user, revoking the previous one.
+func (h *Handler) PostUserToken(c *gin.Context) {
+	user := h.findUserById(c)
+	if user == nil {
+		return
+	}
+
+	token, tokenHash, err := newToken()
+	if err != nil {
+		apierror.Respond(c, apierror.Internal("error generating token: %w", err))
+		return
+	}
+	previousHash := user.TokenHash
+	user.TokenHash = tokenHash
+	h.updateUser(c, user, previousHash, http.StatusCreated, gin.H{"token": token})
+}
+
+func (h *Handler) DeleteUserToken(c *gin.Context) {
+	user := h.findUserById(c)
+	if user == nil {
+		return
+	}
+	if len(user.TokenHash) == 0 {
+		apierror.Respond(c, apierror.NotFound("user %d has no token", user.ID).With("user_id", user.ID))
+		return
+	}
+
+	previousHash := user.TokenHash
+	user.TokenHash = ""
+	h.updateUser(c, user, previousHash, http.StatusNoContent, nil)
+}
+
+// updateUser saves the user and evicts it from the cache by the token hash it had before.
+func (h *Handler) updateUser(c *gin.Context, user *schemas.User, previousHash string, status int, response interface{}) {
+	err := h.Users.Update(c.Request.Context(), user)
+	if errors.Is(err, repository.ErrNotFound) {
+		apierror.Respond(c, apierror.NotFound("user %d not found", user.ID).With("user_id", user.ID))
+		return
+	} else if err != nil {
+		apierror.Respond(c, apierror.Internal("error saving user to database: %w", err))
+		return
+	}
+
+	h.evictUser(c, previousHash)
+	if response == nil {
+		c.Status(status)
+	} else {
+		c.JSON(status, response)
+	}
+}
+
+// checkRoleExists responds with 400 if the role is neither empty nor an existing one.
+func (h *Handler) checkRoleExists(c *gin.Context, name string) bool {
+	if len(name) == 0 {
+		return true
+	}
+
+	_, err := h.Roles.FindByName(c.Request.Context(), name)
+	if errors.Is(err, repository.ErrNotFound) {
+		apierror.Respond(c, apierror.BadRequest("unknown role %q", name).With("role", name))
+		return false
+	} else if err != nil {
+		apierror.Respond(c, apierror.Internal("error getting role from database: %w", err))
+		return false
+	}
+	return true
+}
+
+func (h *Handler) findUserById(c *gin.Context) *schemas.User {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil || id <= 0 {
+		apierror.Respond(c, apierror.BadRequest("invalid user id: must be positive integer"))
+		return nil
+	}
+
+	user, err := h.Users.FindByID(c.Request.Context(), uint(id))
+	if errors.Is(err, repository.ErrNotFound) {
+		apierror.Respond(c, apierror.NotFound("user %d not found", id).With("user_id", id))
+		return nil
+	} else if err != nil {
+		apierror.Respond(c, apierror.Internal("error getting user from database: %w", err))
+		return nil
+	}
+
+	return user
+}
+
+func parseTagId(c *gin.Context) (int64, bool) {
+	tagId, err := strconv.ParseInt(c.Param("tag_id"), 10, 64)
+	if err != nil || tagId <= 0 {
+		apierror.Respond(c, apierror.BadRequest("invalid tag_id: must be positive integer"))
+		return 0, false
+	}
+	return tagId, true
+}
+
+func (h *Handler) GetUserTags(c *gin.Context) {
+	user := h.findUserById(c)
+	if user == nil {
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"user_id": user.ID, "tag_ids": user.TagIDs})
+}
+
+// PutUserTag makes the user a member of the tag, doing nothing if it already is one.
+func (h *Handler) PutUserTag(c *gin.Context) {
+	user := h.findUserById(c)
+	if user == nil {
+		return
+	}
+	tagId, ok := parseTagId(c)
+	if !ok {
+		return
+	}
+
+	err := h.Users.AddTags(c.Request.Context(), user.ID, tagId)
+	if errors.Is(err, repository.ErrNotFound) {
+		apierror.Respond(c, apierror.NotFound("user %d not found", user.ID).With("user_id", user.ID))
+	} else if err != nil {
+		apierror.Respond(c, apierror.Internal("error saving user tags to database: %w", err))
+	} else {
+		h.evictUser(c, user.TokenHash)
+		c.Status(http.StatusNoContent)
+	}
+}
+
+func (h *Handler) DeleteUserTag(c *gin.Context) {
+	user := h.findUserById(c)
+	if user == nil {
+		return
+	}
+	tagId, ok := parseTagId(c)
+	if !ok {
+		return
+	}
+
+	err := h.Users.RemoveTag(c.Request.Context(), user.ID, tagId)
+	if errors.Is(err, repository.ErrNotFound) {
+		apierror.Respond(c, apierror.NotFound("user %d is not a member of tag %d", user.ID, tagId).
+			With("user_id", user.ID).With("tag_id", tagId))
+	} else if err != nil {
+		apierror.Respond(c, apierror.Internal("error deleting user tag from database: %w", err))
+	} else {
+		h.evictUser(c, user.TokenHash)
+		c.Status(http.StatusNoContent)
+	}
+}
+
+// evictUser drops the user cached by its token hash on every replica, so changes apply
+// to the next request.
+func (h *Handler) evictUser(c *gin.Context, tokenHash string) {
+	if len(tokenHash) == 0 {
+		return
+	}
+	if err := db.InvalidateUsers(c.Request.Context(), h.UserCache, tokenHash); err != nil {
+		log.Printf("error evicting user cache entry: %v", err)
+	}
+}
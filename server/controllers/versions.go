@@ -0,0 +1,78 @@
+package controllers
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+
+	"server/apierror"
+	"server/repository"
+)
+
+const defaultVersionsLimit = 10
+
+func (h *Handler) GetBannerVersions(c *gin.Context) {
+	banner := h.findBannerById(c)
+	if banner == nil {
+		return
+	}
+
+	_, _, _, limit, _, err := parseQueries(c)
+	if err != nil {
+		apierror.Respond(c, apierror.BadRequest("error parsing query params: %w", err))
+		return
+	}
+	if limit <= 0 {
+		limit = defaultVersionsLimit
+	}
+
+	versions, err := h.Banners.Versions(c.Request.Context(), banner.ID, limit)
+	if err != nil {
+		apierror.Respond(c, apierror.Internal("error getting banner versions from database: %w", err))
+	} else {
+		c.JSON(http.StatusOK, versions)
+	}
+}
+
+func (h *Handler) RestoreBannerVersion(c *gin.Context) {
+	banner := h.findBannerById(c)
+	if banner == nil {
+		return
+	}
+
+	versionParam := c.Param("version")
+	versionNumber, err := strconv.Atoi(versionParam)
+	if err != nil || versionNumber <= 0 {
+		apierror.Respond(c, apierror.BadRequest("invalid version: must be positive integer"))
+		return
+	}
+
+	version, err := h.Banners.FindVersion(c.Request.Context(), banner.ID, versionNumber)
+	if errors.Is(err, repository.ErrNotFound) {
+		apierror.Respond(c, apierror.NotFound("banner %d has no version %d", banner.ID, versionNumber).
+			With("banner_id", banner.ID).With("version", versionNumber))
+		return
+	} else if err != nil {
+		apierror.Respond(c, apierror.Internal("error getting banner version from database: %w", err))
+		return
+	}
+	previous := banner.Clone()
+	restored := banner.Clone()
+	version.ApplyTo(&restored)
+	if !authorizeBannerChange(c, &previous, &restored) {
+		return
+	}
+
+	err = h.Banners.RestoreVersion(c.Request.Context(), banner, versionNumber)
+	if errors.Is(err, repository.ErrNotFound) {
+		apierror.Respond(c, apierror.NotFound("banner %d has no version %d", banner.ID, versionNumber).
+			With("banner_id", banner.ID).With("version", versionNumber))
+	} else if err != nil {
+		respondBannerWriteError(c, "error restoring banner version", err)
+	} else {
+		h.invalidateBanners(c, &previous, banner)
+		c.JSON(http.StatusOK, banner)
+	}
+}
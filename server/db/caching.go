@@ -1,15 +1,185 @@
 package db
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"sync/atomic"
 	"time"
 
-	"github.com/patrickmn/go-cache"
+	"github.com/redis/go-redis/v9"
+
+	"server/config"
+	"server/schemas"
 )
 
-var BannerCache *cache.Cache
-var UserCache *cache.Cache
+// DefaultExpiration makes Cache.Set use the expiration the cache was created with.
+const DefaultExpiration time.Duration = 0
+
+var ErrInvalidCacheEntry = errors.New("invalid cache entry")
+
+// Cache stores values under string keys for a limited time.
+type Cache interface {
+	// Get loads the value stored under key into dst, which must be a pointer.
+	// It returns false if there is no entry for key.
+	Get(ctx context.Context, key string, dst interface{}) (bool, error)
+	Set(ctx context.Context, key string, value interface{}, expiration time.Duration) error
+	Delete(ctx context.Context, keys ...string) error
+	Stats() CacheStats
+}
+
+var BannerCache Cache
+var UserCache Cache
+var RoleCache Cache
+
+// BannerCacheTTL is how long a cached banner is considered fresh, BannerCacheNotFoundTTL is
+// the same for a lookup that found no banner. With BannerCacheStaleTTL set, the entry is kept
+// for that much longer and served while it is being refreshed. InitCaches sets them from the config.
+var BannerCacheTTL time.Duration
+var BannerCacheNotFoundTTL time.Duration
+var BannerCacheStaleTTL time.Duration
+
+// BannerCacheEntry is the value stored in BannerCache. Banner is nil when no banner
+// matches the key.
+type BannerCacheEntry struct {
+	Banner    *schemas.Banner `json:"banner"`
+	RefreshAt time.Time       `json:"refresh_at"`
+}
+
+// InitCaches creates the caches with the configured backend: "memory" keeps entries
+// in the process, "redis" shares them between replicas.
+func InitCaches(cfg config.CacheConfig) {
+	BannerCacheTTL = cfg.Banner.TTL
+	BannerCacheNotFoundTTL = cfg.Banner.NotFoundTTL
+	BannerCacheStaleTTL = cfg.Banner.StaleTTL
+
+	switch cfg.Backend {
+	case "memory":
+		BannerCache = NewMemoryCache(MemoryCacheOptions{
+			DefaultExpiration: cfg.Banner.TTL,
+			CleanupInterval:   10 * time.Minute,
+			MaxEntries:        cfg.Banner.MaxEntries,
+			MaxBytes:          cfg.Banner.MaxBytes,
+		})
+		UserCache = NewMemoryCache(MemoryCacheOptions{
+			DefaultExpiration: cfg.User.TTL,
+			CleanupInterval:   24 * time.Hour,
+			MaxEntries:        cfg.User.MaxEntries,
+			MaxBytes:          cfg.User.MaxBytes,
+		})
+		RoleCache = NewMemoryCache(MemoryCacheOptions{
+			DefaultExpiration: cfg.Role.TTL,
+			CleanupInterval:   10 * time.Minute,
+			MaxEntries:        cfg.Role.MaxEntries,
+			MaxBytes:          cfg.Role.MaxBytes,
+		})
+	case "redis":
+		client := newRedisClient(cfg.RedisURL)
+		BannerCache = NewRedisCache(client, "banner:", cfg.Banner.TTL)
+		UserCache = NewRedisCache(client, "user:", cfg.User.TTL)
+		RoleCache = NewRedisCache(client, "role:", cfg.Role.TTL)
+	default:
+		log.Fatalf("unknown cache backend %q", cfg.Backend)
+	}
+
+	if len(cfg.InvalidationChannel) > 0 {
+		subscribeToInvalidations(newRedisClient(cfg.RedisURL), cfg.InvalidationChannel, BannerCache, UserCache, RoleCache)
+	}
+}
+
+// redisClients are closed by CloseCaches.
+var redisClients []*redis.Client
+
+func newRedisClient(url string) *redis.Client {
+	options, err := redis.ParseURL(url)
+	if err != nil {
+		log.Fatal(fmt.Errorf("invalid redis url: %w", err))
+	}
+	client := redis.NewClient(options)
+	redisClients = append(redisClients, client)
+	return client
+}
+
+// CloseCaches stops the janitors of memory caches, the invalidation subscriber
+// and closes connections to Redis.
+func CloseCaches() error {
+	var errs []error
+	for _, cache := range []Cache{BannerCache, UserCache, RoleCache} {
+		if closer, ok := cache.(io.Closer); ok {
+			errs = append(errs, closer.Close())
+		}
+	}
+	if invalidationSubscription != nil {
+		errs = append(errs, invalidationSubscription.Close())
+		invalidationSubscription = nil
+	}
+	for _, client := range redisClients {
+		errs = append(errs, client.Close())
+	}
+	redisClients = nil
+	invalidationClient = nil
+	return errors.Join(errs...)
+}
+
+type redisCache struct {
+	client     *redis.Client
+	prefix     string
+	expiration time.Duration
+
+	hits   atomic.Uint64
+	misses atomic.Uint64
+}
+
+// NewRedisCache stores JSON encoded values in Redis under keys starting with prefix.
+func NewRedisCache(client *redis.Client, prefix string, defaultExpiration time.Duration) Cache {
+	return &redisCache{client: client, prefix: prefix, expiration: defaultExpiration}
+}
+
+func (c *redisCache) Get(ctx context.Context, key string, dst interface{}) (bool, error) {
+	data, err := c.client.Get(ctx, c.prefix+key).Bytes()
+	if errors.Is(err, redis.Nil) {
+		c.misses.Add(1)
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+	c.hits.Add(1)
+
+	if err = json.Unmarshal(data, dst); err != nil {
+		return false, fmt.Errorf("%w: %w", ErrInvalidCacheEntry, err)
+	}
+	return true, nil
+}
+
+func (c *redisCache) Set(ctx context.Context, key string, value interface{}, expiration time.Duration) error {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+
+	if expiration == DefaultExpiration {
+		expiration = c.expiration
+	}
+	return c.client.Set(ctx, c.prefix+key, data, expiration).Err()
+}
+
+func (c *redisCache) Delete(ctx context.Context, keys ...string) error {
+	if len(keys) == 0 {
+		return nil
+	}
+
+	prefixed := make([]string, len(keys))
+	for i, key := range keys {
+		prefixed[i] = c.prefix + key
+	}
+	return c.client.Del(ctx, prefixed...).Err()
+}
 
-func InitCaches() {
-	BannerCache = cache.New(5*time.Minute, 10*time.Minute)
-	UserCache = cache.New(1*time.Hour, 24*time.Hour)
+// Stats reports hits and misses of this replica only. Redis evicts entries on its own,
+// so evictions and size are not tracked.
+func (c *redisCache) Stats() CacheStats {
+	return CacheStats{Hits: c.hits.Load(), Misses: c.misses.Load()}
 }
@@ -1,37 +1,50 @@
 package db
 
 import (
+	"context"
 	"log"
-	"os"
 
 	"gorm.io/driver/postgres"
 	"gorm.io/gorm"
 
-	"server/schemas"
+	"server/config"
 )
 
 var DB *gorm.DB
 
-func ConnectToDb() {
-	dsn := os.Getenv("DB_URL")
+// ConnectToDb opens the connection pool. The schema is managed by the migrate
+// subcommand, see MigrateUp and CheckSchemaVersion.
+func ConnectToDb(cfg config.DatabaseConfig) {
 	var err error
-	DB, err = gorm.Open(postgres.Open(dsn), &gorm.Config{})
+	DB, err = gorm.Open(postgres.Open(cfg.URL), &gorm.Config{TranslateError: true})
 	if err != nil {
 		log.Fatal(err)
 	}
 
-	if err := DB.AutoMigrate(&schemas.User{}, &schemas.Banner{}); err != nil {
+	sqlDB, err := DB.DB()
+	if err != nil {
 		log.Fatal(err)
 	}
+	sqlDB.SetMaxOpenConns(cfg.MaxOpenConns)
+	sqlDB.SetMaxIdleConns(cfg.MaxIdleConns)
+	sqlDB.SetConnMaxLifetime(cfg.ConnMaxLifetime)
+	sqlDB.SetConnMaxIdleTime(cfg.ConnMaxIdleTime)
+}
 
-	user := schemas.User{Token: "user_token", IsAdmin: false}
-	admin := schemas.User{Token: "admin_token", IsAdmin: true}
-	res := DB.Model(&schemas.User{}).Create(&user)
-	if res.Error != nil {
-		log.Fatal(err)
+// Close closes the connection pool, waiting for queries in progress to finish.
+func Close() error {
+	sqlDB, err := DB.DB()
+	if err != nil {
+		return err
 	}
-	res = DB.Model(&schemas.User{}).Create(&admin)
-	if res.Error != nil {
-		log.Fatal(err)
+	return sqlDB.Close()
+}
+
+// Ping checks that the database is reachable.
+func Ping(ctx context.Context) error {
+	sqlDB, err := DB.DB()
+	if err != nil {
+		return err
 	}
+	return sqlDB.PingContext(ctx)
 }
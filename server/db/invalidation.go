@@ -0,0 +1,150 @@
+package db
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+
+	"server/schemas"
+)
+
+var invalidationClient *redis.Client
+var invalidationChannel string
+var invalidationSubscription *redis.PubSub
+
+func BannerCacheKey(tagId int64, featureId int) string {
+	return fmt.Sprintf("%d,%d", tagId, featureId)
+}
+
+// InvalidateBanners evicts from cache the entries of every (tag_id, feature_id) pair
+// of the given banners. When an invalidation channel is configured, the keys are also
+// published there so that other replicas evict them from their local caches.
+func InvalidateBanners(ctx context.Context, cache Cache, banners ...*schemas.Banner) error {
+	var keys []string
+	for _, banner := range banners {
+		for _, tagId := range banner.TagIDs {
+			keys = append(keys, BannerCacheKey(tagId, banner.FeatureID))
+		}
+	}
+	bumpBannerGenerations(keys)
+	return invalidate(ctx, cache, invalidationChannel, keys)
+}
+
+// bannerGenerations count invalidations of banner keys, so a lookup that read the
+// database before a write committed doesn't cache its result after the write has
+// invalidated the key. Keys share a fixed number of stripes; a collision only makes
+// a lookup skip caching.
+var bannerGenerations [256]struct {
+	mu         sync.Mutex
+	generation uint64
+}
+
+func bannerStripe(key string) int {
+	h := fnv.New32a()
+	_, _ = h.Write([]byte(key))
+	return int(h.Sum32() % uint32(len(bannerGenerations)))
+}
+
+func bumpBannerGenerations(keys []string) {
+	for _, key := range keys {
+		stripe := &bannerGenerations[bannerStripe(key)]
+		stripe.mu.Lock()
+		stripe.generation++
+		stripe.mu.Unlock()
+	}
+}
+
+// BannerGeneration returns the invalidation generation of the banner key, to be read
+// before looking the banner up in the database and passed to SetBannerIfCurrent.
+func BannerGeneration(key string) uint64 {
+	stripe := &bannerGenerations[bannerStripe(key)]
+	stripe.mu.Lock()
+	defer stripe.mu.Unlock()
+	return stripe.generation
+}
+
+// SetBannerIfCurrent caches the entry unless the key has been invalidated since
+// generation was read, since the entry could then predate the write. An invalidation
+// racing with it either sees the entry cached and deletes it, or makes it skipped.
+// It reports whether the entry was cached.
+func SetBannerIfCurrent(ctx context.Context, cache Cache, key string, generation uint64, entry BannerCacheEntry, expiration time.Duration) (bool, error) {
+	stripe := &bannerGenerations[bannerStripe(key)]
+	stripe.mu.Lock()
+	defer stripe.mu.Unlock()
+	if stripe.generation != generation {
+		return false, nil
+	}
+	return true, cache.Set(ctx, key, entry, expiration)
+}
+
+// InvalidateUsers evicts users cached by their token hashes, on other replicas too
+// when an invalidation channel is configured, so revoked tokens stop working everywhere.
+func InvalidateUsers(ctx context.Context, cache Cache, tokenHashes ...string) error {
+	return invalidate(ctx, cache, invalidationChannel+userChannelSuffix, tokenHashes)
+}
+
+// InvalidateRoles evicts roles cached by their names, on other replicas too when an
+// invalidation channel is configured.
+func InvalidateRoles(ctx context.Context, cache Cache, names ...string) error {
+	return invalidate(ctx, cache, invalidationChannel+roleChannelSuffix, names)
+}
+
+// User and role keys are published to their own channels, so banner messages stay
+// compatible with replicas not knowing about them.
+const (
+	userChannelSuffix = ":user"
+	roleChannelSuffix = ":role"
+)
+
+func invalidate(ctx context.Context, cache Cache, channel string, keys []string) error {
+	if len(keys) == 0 {
+		return nil
+	}
+
+	if err := cache.Delete(ctx, keys...); err != nil {
+		return err
+	}
+	if invalidationClient == nil {
+		return nil
+	}
+
+	message, err := json.Marshal(keys)
+	if err != nil {
+		return err
+	}
+	return invalidationClient.Publish(ctx, channel, message).Err()
+}
+
+func subscribeToInvalidations(client *redis.Client, channel string, banners, users, roles Cache) {
+	invalidationClient = client
+	invalidationChannel = channel
+	caches := map[string]Cache{
+		channel:                     banners,
+		channel + userChannelSuffix: users,
+		channel + roleChannelSuffix: roles,
+	}
+
+	pubsub := client.Subscribe(context.Background(), channel, channel+userChannelSuffix, channel+roleChannelSuffix)
+	invalidationSubscription = pubsub
+	go func() {
+		for message := range pubsub.Channel() {
+			var keys []string
+			if err := json.Unmarshal([]byte(message.Payload), &keys); err != nil {
+				log.Printf("invalid cache invalidation message: %v", err)
+				continue
+			}
+			if message.Channel == channel {
+				bumpBannerGenerations(keys)
+			}
+			if err := caches[message.Channel].Delete(context.Background(), keys...); err != nil {
+				log.Printf("error evicting cache entries from %s: %v", message.Channel, err)
+			}
+		}
+	}()
+}
@@ -0,0 +1,202 @@
+package db
+
+import (
+	"container/list"
+	"context"
+	"encoding/json"
+	"reflect"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+type CacheStats struct {
+	Hits      uint64
+	Misses    uint64
+	Evictions uint64
+	Entries   int
+	Bytes     int64
+}
+
+type MemoryCacheOptions struct {
+	DefaultExpiration time.Duration
+	// CleanupInterval is how often expired entries are removed in background.
+	// Zero disables the janitor, expired entries are then dropped only on access or eviction.
+	CleanupInterval time.Duration
+	// MaxEntries and MaxBytes bound the cache size, zero means no limit.
+	// Entry size is estimated as the length of its key and JSON encoded value.
+	MaxEntries int
+	MaxBytes   int64
+}
+
+// MemoryCache is a process-local LRU cache with per-entry expiration.
+// When a limit is reached, least recently used entries are evicted.
+type MemoryCache struct {
+	options MemoryCacheOptions
+
+	mu    sync.Mutex
+	items map[string]*list.Element
+	order *list.List
+	bytes int64
+
+	hits      atomic.Uint64
+	misses    atomic.Uint64
+	evictions atomic.Uint64
+
+	stop     chan struct{}
+	stopOnce sync.Once
+}
+
+type memoryCacheEntry struct {
+	key       string
+	value     interface{}
+	size      int64
+	expiresAt time.Time
+}
+
+func NewMemoryCache(options MemoryCacheOptions) *MemoryCache {
+	c := &MemoryCache{
+		options: options,
+		items:   make(map[string]*list.Element),
+		order:   list.New(),
+		stop:    make(chan struct{}),
+	}
+	if options.CleanupInterval > 0 {
+		go c.runJanitor()
+	}
+	return c
+}
+
+func (c *MemoryCache) Get(_ context.Context, key string, dst interface{}) (bool, error) {
+	c.mu.Lock()
+	element, ok := c.items[key]
+	if ok && element.Value.(*memoryCacheEntry).expired(time.Now()) {
+		c.removeElement(element)
+		ok = false
+	}
+	if !ok {
+		c.mu.Unlock()
+		c.misses.Add(1)
+		return false, nil
+	}
+	c.order.MoveToFront(element)
+	v := element.Value.(*memoryCacheEntry).value
+	c.mu.Unlock()
+	c.hits.Add(1)
+
+	dstValue := reflect.ValueOf(dst)
+	value := reflect.ValueOf(v)
+	if dstValue.Kind() != reflect.Pointer || !value.Type().AssignableTo(dstValue.Elem().Type()) {
+		return false, ErrInvalidCacheEntry
+	}
+	dstValue.Elem().Set(value)
+	return true, nil
+}
+
+func (c *MemoryCache) Set(_ context.Context, key string, value interface{}, expiration time.Duration) error {
+	size, err := entrySize(key, value)
+	if err != nil {
+		return err
+	}
+	if expiration == DefaultExpiration {
+		expiration = c.options.DefaultExpiration
+	}
+	entry := &memoryCacheEntry{key: key, value: value, size: size}
+	if expiration > 0 {
+		entry.expiresAt = time.Now().Add(expiration)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if element, ok := c.items[key]; ok {
+		c.removeElement(element)
+	}
+	if c.options.MaxBytes > 0 && size > c.options.MaxBytes {
+		return nil
+	}
+
+	c.items[key] = c.order.PushFront(entry)
+	c.bytes += size
+	for c.overflows() {
+		c.removeElement(c.order.Back())
+		c.evictions.Add(1)
+	}
+	return nil
+}
+
+func (c *MemoryCache) Delete(_ context.Context, keys ...string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for _, key := range keys {
+		if element, ok := c.items[key]; ok {
+			c.removeElement(element)
+		}
+	}
+	return nil
+}
+
+func (c *MemoryCache) Stats() CacheStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return CacheStats{
+		Hits:      c.hits.Load(),
+		Misses:    c.misses.Load(),
+		Evictions: c.evictions.Load(),
+		Entries:   len(c.items),
+		Bytes:     c.bytes,
+	}
+}
+
+// Close stops the janitor goroutine.
+func (c *MemoryCache) Close() error {
+	c.stopOnce.Do(func() { close(c.stop) })
+	return nil
+}
+
+func (c *MemoryCache) overflows() bool {
+	return (c.options.MaxEntries > 0 && len(c.items) > c.options.MaxEntries) ||
+		(c.options.MaxBytes > 0 && c.bytes > c.options.MaxBytes)
+}
+
+func (c *MemoryCache) removeElement(element *list.Element) {
+	entry := c.order.Remove(element).(*memoryCacheEntry)
+	delete(c.items, entry.key)
+	c.bytes -= entry.size
+}
+
+func (c *MemoryCache) runJanitor() {
+	ticker := time.NewTicker(c.options.CleanupInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-c.stop:
+			return
+		case now := <-ticker.C:
+			c.mu.Lock()
+			for element := c.order.Back(); element != nil; {
+				prev := element.Prev()
+				if element.Value.(*memoryCacheEntry).expired(now) {
+					c.removeElement(element)
+				}
+				element = prev
+			}
+			c.mu.Unlock()
+		}
+	}
+}
+
+func (e *memoryCacheEntry) expired(now time.Time) bool {
+	return !e.expiresAt.IsZero() && now.After(e.expiresAt)
+}
+
+func entrySize(key string, value interface{}) (int64, error) {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return 0, err
+	}
+	return int64(len(key) + len(data)), nil
+}
@@ -0,0 +1,248 @@
+package db
+
+import (
+	"context"
+	"embed"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+
+	"gorm.io/gorm"
+)
+
+//go:embed migrations/*.sql
+var migrationFiles embed.FS
+
+// migrationLockId is the key of the advisory lock serializing migrations run
+// by several instances at once.
+const migrationLockId = 7328411
+
+var migrationFileName = regexp.MustCompile(`^(\d+)_(\w+)\.(up|down)\.sql$`)
+
+// Migration is a pair of SQL scripts moving the schema to Version and back.
+type Migration struct {
+	Version int
+	Name    string
+	Up      string
+	Down    string
+}
+
+// Migrations returns the embedded migrations ordered by version. Versions have to go
+// one by one starting from 1 and every migration needs both scripts.
+func Migrations() ([]Migration, error) {
+	entries, err := fs.ReadDir(migrationFiles, "migrations")
+	if err != nil {
+		return nil, err
+	}
+
+	byVersion := make(map[int]*Migration)
+	for _, entry := range entries {
+		match := migrationFileName.FindStringSubmatch(entry.Name())
+		if match == nil {
+			return nil, fmt.Errorf("unexpected migration file %s", entry.Name())
+		}
+		version, _ := strconv.Atoi(match[1])
+		script, err := fs.ReadFile(migrationFiles, path.Join("migrations", entry.Name()))
+		if err != nil {
+			return nil, err
+		}
+
+		migration, ok := byVersion[version]
+		if !ok {
+			migration = &Migration{Version: version, Name: match[2]}
+			byVersion[version] = migration
+		} else if migration.Name != match[2] {
+			return nil, fmt.Errorf("migration %d has different names %s and %s", version, migration.Name, match[2])
+		}
+		if match[3] == "up" {
+			migration.Up = string(script)
+		} else {
+			migration.Down = string(script)
+		}
+	}
+
+	migrations := make([]Migration, 0, len(byVersion))
+	for _, migration := range byVersion {
+		if len(migration.Up) == 0 || len(migration.Down) == 0 {
+			return nil, fmt.Errorf("migration %d_%s should have both up and down scripts", migration.Version, migration.Name)
+		}
+		migrations = append(migrations, *migration)
+	}
+	sort.Slice(migrations, func(i, j int) bool { return migrations[i].Version < migrations[j].Version })
+	for i, migration := range migrations {
+		if migration.Version != i+1 {
+			return nil, fmt.Errorf("migration %d_%s is out of sequence, expected version %d", migration.Version, migration.Name, i+1)
+		}
+	}
+	return migrations, nil
+}
+
+// LatestSchemaVersion is the version the embedded migrations bring the schema to.
+func LatestSchemaVersion() (int, error) {
+	migrations, err := Migrations()
+	if err != nil {
+		return 0, err
+	}
+	return len(migrations), nil
+}
+
+// SchemaVersion returns the version of the last applied migration, 0 if there are none.
+func SchemaVersion(ctx context.Context) (int, error) {
+	return schemaVersion(DB.WithContext(ctx))
+}
+
+func schemaVersion(tx *gorm.DB) (int, error) {
+	if !tx.Migrator().HasTable("schema_migrations") {
+		return 0, nil
+	}
+
+	var version int
+	err := tx.Raw("SELECT COALESCE(max(version), 0) FROM schema_migrations").Scan(&version).Error
+	return version, err
+}
+
+// CheckSchemaVersion returns an error unless all the embedded migrations are applied
+// and none are unknown to this build.
+func CheckSchemaVersion(ctx context.Context) error {
+	latest, err := LatestSchemaVersion()
+	if err != nil {
+		return err
+	}
+	current, err := SchemaVersion(ctx)
+	if err != nil {
+		return fmt.Errorf("error getting schema version: %w", err)
+	}
+	if current != latest {
+		return fmt.Errorf("database schema version is %d, but this build expects %d: run migrate", current, latest)
+	}
+	return nil
+}
+
+// MigrateUp applies the migrations that are not applied yet, each in its own transaction.
+func MigrateUp() error {
+	migrations, err := Migrations()
+	if err != nil {
+		return err
+	}
+
+	err = DB.Exec(`CREATE TABLE IF NOT EXISTS schema_migrations (
+		version bigint PRIMARY KEY,
+		name text NOT NULL,
+		applied_at timestamptz NOT NULL DEFAULT now()
+	)`).Error
+	if err != nil {
+		return err
+	}
+
+	for _, migration := range migrations {
+		migration := migration
+		err := DB.Transaction(func(tx *gorm.DB) error {
+			current, err := lockSchema(tx)
+			if err != nil || current >= migration.Version {
+				return err
+			}
+
+			log.Printf("applying migration %d_%s", migration.Version, migration.Name)
+			if err := tx.Exec(migration.Up).Error; err != nil {
+				return err
+			}
+			return tx.Exec("INSERT INTO schema_migrations (version, name) VALUES (?, ?)", migration.Version, migration.Name).Error
+		})
+		if err != nil {
+			return fmt.Errorf("error applying migration %d_%s: %w", migration.Version, migration.Name, err)
+		}
+	}
+	return nil
+}
+
+// MigrateDown reverts up to steps latest applied migrations.
+func MigrateDown(steps int) error {
+	migrations, err := Migrations()
+	if err != nil {
+		return err
+	}
+
+	for i := 0; i < steps; i++ {
+		reverted := false
+		err := DB.Transaction(func(tx *gorm.DB) error {
+			current, err := lockSchema(tx)
+			if err != nil || current == 0 {
+				return err
+			}
+			if current > len(migrations) {
+				return fmt.Errorf("schema version %d is unknown to this build", current)
+			}
+
+			migration := migrations[current-1]
+			log.Printf("reverting migration %d_%s", migration.Version, migration.Name)
+			if err := tx.Exec(migration.Down).Error; err != nil {
+				return fmt.Errorf("error reverting migration %d_%s: %w", migration.Version, migration.Name, err)
+			}
+			reverted = true
+			return tx.Exec("DELETE FROM schema_migrations WHERE version = ?", migration.Version).Error
+		})
+		if err != nil {
+			return err
+		}
+		if !reverted {
+			break
+		}
+	}
+	return nil
+}
+
+// lockSchema takes the migration lock until the end of the transaction and returns
+// the schema version, which can't change while the lock is held.
+func lockSchema(tx *gorm.DB) (int, error) {
+	if err := tx.Exec("SELECT pg_advisory_xact_lock(?)", migrationLockId).Error; err != nil {
+		return 0, err
+	}
+	return schemaVersion(tx)
+}
+
+var errUnknownMigrateCommand = errors.New("usage: migrate [up | down [steps] | status]")
+
+// RunMigrateCommand runs the migrate subcommand with its arguments.
+func RunMigrateCommand(args []string) error {
+	command := "up"
+	if len(args) > 0 {
+		command = args[0]
+	}
+
+	switch command {
+	case "up":
+		if len(args) > 1 {
+			return errUnknownMigrateCommand
+		}
+		return MigrateUp()
+	case "down":
+		steps := 1
+		if len(args) > 2 {
+			return errUnknownMigrateCommand
+		} else if len(args) == 2 {
+			var err error
+			if steps, err = strconv.Atoi(args[1]); err != nil || steps < 1 {
+				return errors.New("invalid steps: must be positive integer")
+			}
+		}
+		return MigrateDown(steps)
+	case "status":
+		current, err := SchemaVersion(context.Background())
+		if err != nil {
+			return err
+		}
+		latest, err := LatestSchemaVersion()
+		if err != nil {
+			return err
+		}
+		log.Printf("schema version %d, latest %d", current, latest)
+		return nil
+	default:
+		return errUnknownMigrateCommand
+	}
+}
@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS banners;
+DROP TABLE IF EXISTS users;
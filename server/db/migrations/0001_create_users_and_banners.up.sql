@@ -0,0 +1,23 @@
+-- Tables as they were created by AutoMigrate before migrations were introduced,
+-- so databases created back then are adopted as they are.
+CREATE TABLE IF NOT EXISTS users (
+	id bigserial PRIMARY KEY,
+	created_at timestamptz,
+	updated_at timestamptz,
+	deleted_at timestamptz,
+	token text,
+	is_admin boolean
+);
+CREATE INDEX IF NOT EXISTS idx_users_deleted_at ON users (deleted_at);
+
+CREATE TABLE IF NOT EXISTS banners (
+	id bigserial PRIMARY KEY,
+	created_at timestamptz,
+	updated_at timestamptz,
+	deleted_at timestamptz,
+	feature_id bigint,
+	is_active boolean,
+	tag_ids integer[],
+	content jsonb
+);
+CREATE INDEX IF NOT EXISTS idx_banners_deleted_at ON banners (deleted_at);
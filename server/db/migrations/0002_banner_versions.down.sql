@@ -0,0 +1,6 @@
+DROP TABLE IF EXISTS banner_versions;
+
+ALTER TABLE banners
+	DROP COLUMN IF EXISTS version,
+	DROP COLUMN IF EXISTS ends_at,
+	DROP COLUMN IF EXISTS starts_at;
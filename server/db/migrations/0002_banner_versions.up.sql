@@ -0,0 +1,18 @@
+ALTER TABLE banners
+	ADD COLUMN IF NOT EXISTS starts_at timestamptz,
+	ADD COLUMN IF NOT EXISTS ends_at timestamptz,
+	ADD COLUMN IF NOT EXISTS version bigint NOT NULL DEFAULT 1;
+
+CREATE TABLE IF NOT EXISTS banner_versions (
+	id bigserial PRIMARY KEY,
+	banner_id bigint,
+	version bigint,
+	created_at timestamptz,
+	feature_id bigint,
+	is_active boolean,
+	tag_ids integer[],
+	content jsonb,
+	starts_at timestamptz,
+	ends_at timestamptz
+);
+CREATE UNIQUE INDEX IF NOT EXISTS idx_banner_versions_banner_version ON banner_versions (banner_id, version);
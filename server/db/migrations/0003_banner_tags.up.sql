@@ -0,0 +1,33 @@
+-- banner_tags makes every (tag_id, feature_id) pair belong to a single banner.
+CREATE TABLE IF NOT EXISTS banner_tags (
+	banner_id bigint,
+	tag_id bigint,
+	feature_id bigint,
+	PRIMARY KEY (banner_id, tag_id)
+);
+CREATE UNIQUE INDEX IF NOT EXISTS idx_banner_tags_tag_feature ON banner_tags (tag_id, feature_id);
+
+-- Pairs claimed by several existing banners are reported and only the oldest banner
+-- keeps the pair, so conflicts have to be resolved by hand.
+DO $$
+DECLARE
+	conflict record;
+BEGIN
+	FOR conflict IN
+		SELECT t.tag_id, b.feature_id, array_agg(b.id ORDER BY b.id) AS banner_ids
+		FROM banners b, unnest(b.tag_ids) AS t(tag_id)
+		WHERE b.deleted_at IS NULL
+		GROUP BY t.tag_id, b.feature_id
+		HAVING count(DISTINCT b.id) > 1
+	LOOP
+		RAISE WARNING 'banner conflict: tag_id % and feature_id % are claimed by banners %, keeping banner %',
+			conflict.tag_id, conflict.feature_id, conflict.banner_ids, conflict.banner_ids[1];
+	END LOOP;
+END $$;
+
+INSERT INTO banner_tags (banner_id, tag_id, feature_id)
+SELECT DISTINCT ON (t.tag_id, b.feature_id) b.id, t.tag_id, b.feature_id
+FROM banners b, unnest(b.tag_ids) AS t(tag_id)
+WHERE b.deleted_at IS NULL
+ORDER BY t.tag_id, b.feature_id, b.id
+ON CONFLICT DO NOTHING;
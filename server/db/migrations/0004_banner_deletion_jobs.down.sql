@@ -0,0 +1 @@
+DROP TABLE IF EXISTS banner_deletion_jobs;
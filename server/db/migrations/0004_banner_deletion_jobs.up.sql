@@ -0,0 +1,12 @@
+CREATE TABLE IF NOT EXISTS banner_deletion_jobs (
+	id bigserial PRIMARY KEY,
+	created_at timestamptz,
+	updated_at timestamptz,
+	feature_id bigint,
+	tag_id bigint,
+	status text,
+	total bigint,
+	deleted bigint,
+	error text
+);
+CREATE INDEX IF NOT EXISTS idx_banner_deletion_jobs_status ON banner_deletion_jobs (status);
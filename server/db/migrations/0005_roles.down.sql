@@ -0,0 +1,7 @@
+ALTER TABLE users ADD COLUMN IF NOT EXISTS is_admin boolean;
+UPDATE users SET is_admin = (role = 'admin');
+
+DROP INDEX IF EXISTS idx_users_role;
+ALTER TABLE users DROP COLUMN IF EXISTS role;
+
+DROP TABLE IF EXISTS roles;
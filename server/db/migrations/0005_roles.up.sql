@@ -0,0 +1,28 @@
+CREATE TABLE IF NOT EXISTS roles (
+	name text PRIMARY KEY,
+	created_at timestamptz,
+	updated_at timestamptz,
+	permissions text[]
+);
+
+INSERT INTO roles (name, created_at, updated_at, permissions) VALUES
+	('viewer', now(), now(), ARRAY['banners:read']),
+	('editor', now(), now(), ARRAY['banners:read', 'banners:create', 'banners:edit']),
+	('publisher', now(), now(), ARRAY['banners:read', 'banners:toggle']),
+	('admin', now(), now(), ARRAY['banners:read', 'banners:create', 'banners:edit', 'banners:toggle', 'banners:delete', 'roles:manage'])
+ON CONFLICT (name) DO NOTHING;
+
+ALTER TABLE users ADD COLUMN IF NOT EXISTS role text;
+CREATE INDEX IF NOT EXISTS idx_users_role ON users (role);
+
+-- Users are moved from the former is_admin flag to roles.
+DO $$
+BEGIN
+	IF EXISTS (SELECT 1 FROM information_schema.columns
+		WHERE table_schema = current_schema() AND table_name = 'users' AND column_name = 'is_admin') THEN
+		UPDATE users SET role = CASE WHEN is_admin THEN 'admin' ELSE '' END WHERE role IS NULL OR role = '';
+		ALTER TABLE users DROP COLUMN is_admin;
+	END IF;
+END $$;
+
+UPDATE users SET role = '' WHERE role IS NULL;
@@ -0,0 +1,8 @@
+UPDATE roles SET permissions = array_remove(permissions, 'users:manage');
+
+-- Plaintext tokens can't be recovered from hashes, every user has to be issued a new token.
+DROP INDEX IF EXISTS idx_users_token_hash;
+ALTER TABLE users DROP COLUMN IF EXISTS token_hash;
+ALTER TABLE users ADD COLUMN IF NOT EXISTS token text;
+
+DROP TABLE IF EXISTS user_tags;
@@ -0,0 +1,27 @@
+CREATE TABLE IF NOT EXISTS user_tags (
+	user_id bigint,
+	tag_id bigint,
+	PRIMARY KEY (user_id, tag_id)
+);
+CREATE INDEX IF NOT EXISTS idx_user_tags_tag_id ON user_tags (tag_id);
+
+ALTER TABLE users ADD COLUMN IF NOT EXISTS token_hash text;
+
+-- Plaintext tokens are replaced with their hashes. Users sharing a token, as the seed
+-- used to create on every start, keep it only for the oldest one.
+DO $$
+BEGIN
+	IF EXISTS (SELECT 1 FROM information_schema.columns
+		WHERE table_schema = current_schema() AND table_name = 'users' AND column_name = 'token') THEN
+		UPDATE users u SET token_hash = encode(sha256(convert_to(u.token, 'UTF8')), 'hex')
+		WHERE u.token <> '' AND (u.token_hash IS NULL OR u.token_hash = '')
+			AND u.id = (SELECT min(d.id) FROM users d WHERE d.token = u.token);
+		ALTER TABLE users DROP COLUMN token;
+	END IF;
+END $$;
+
+UPDATE users SET token_hash = '' WHERE token_hash IS NULL;
+CREATE UNIQUE INDEX IF NOT EXISTS idx_users_token_hash ON users (token_hash) WHERE token_hash <> '';
+
+UPDATE roles SET permissions = array_append(permissions, 'users:manage')
+WHERE name = 'admin' AND NOT 'users:manage' = ANY(permissions);
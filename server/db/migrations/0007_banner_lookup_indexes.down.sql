@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS idx_banners_feature_id;
+DROP INDEX IF EXISTS idx_banners_tag_ids;
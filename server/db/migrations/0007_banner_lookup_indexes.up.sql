@@ -0,0 +1,6 @@
+-- GetUserBanner and GetBanners filter with tag_ids @> ARRAY[?] and by feature_id,
+-- GORM adds deleted_at IS NULL to both, so partial indexes over live banners suffice.
+CREATE INDEX IF NOT EXISTS idx_banners_tag_ids ON banners USING GIN (tag_ids) WHERE deleted_at IS NULL;
+
+-- Listing by feature is ordered by id, so the index returns pages without sorting.
+CREATE INDEX IF NOT EXISTS idx_banners_feature_id ON banners (feature_id, id) WHERE deleted_at IS NULL;
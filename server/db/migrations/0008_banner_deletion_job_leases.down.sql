@@ -0,0 +1 @@
+ALTER TABLE banner_deletion_jobs DROP COLUMN IF EXISTS lease_until;
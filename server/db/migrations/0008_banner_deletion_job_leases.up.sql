@@ -0,0 +1,3 @@
+-- Workers claim jobs by setting status to running with a lease, and take over running
+-- jobs whose lease has expired, so a job is never run by two replicas at once.
+ALTER TABLE banner_deletion_jobs ADD COLUMN IF NOT EXISTS lease_until timestamptz;
@@ -0,0 +1,88 @@
+// Package health reports whether the server can take traffic.
+package health
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Check returns an error when the dependency it probes is unavailable.
+type Check func(ctx context.Context) error
+
+// Result is the outcome of one check.
+type Result struct {
+	Status    string `json:"status"`
+	Error     string `json:"error,omitempty"`
+	LatencyMs int64  `json:"latency_ms"`
+}
+
+const (
+	StatusOK           = "ok"
+	StatusUnavailable  = "unavailable"
+	StatusShuttingDown = "shutting_down"
+)
+
+type namedCheck struct {
+	name  string
+	check Check
+}
+
+// Checker runs readiness checks. It is safe for concurrent use once the checks are added.
+type Checker struct {
+	checks       []namedCheck
+	shuttingDown atomic.Bool
+}
+
+func NewChecker() *Checker {
+	return &Checker{}
+}
+
+// Add registers a check reported under name.
+func (c *Checker) Add(name string, check Check) {
+	c.checks = append(c.checks, namedCheck{name: name, check: check})
+}
+
+// SetShuttingDown makes the server report not ready regardless of the checks,
+// so that load balancers stop sending it requests before it stops.
+func (c *Checker) SetShuttingDown() {
+	c.shuttingDown.Store(true)
+}
+
+// Ready runs all checks concurrently and returns the overall status along with
+// a result per check.
+func (c *Checker) Ready(ctx context.Context) (string, map[string]Result) {
+	results := make(map[string]Result, len(c.checks))
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for _, nc := range c.checks {
+		nc := nc
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			start := time.Now()
+			err := nc.check(ctx)
+			result := Result{Status: StatusOK, LatencyMs: time.Since(start).Milliseconds()}
+			if err != nil {
+				result.Status = StatusUnavailable
+				result.Error = err.Error()
+			}
+
+			mu.Lock()
+			results[nc.name] = result
+			mu.Unlock()
+		}()
+	}
+	wg.Wait()
+
+	if c.shuttingDown.Load() {
+		return StatusShuttingDown, results
+	}
+	for _, result := range results {
+		if result.Status != StatusOK {
+			return StatusUnavailable, results
+		}
+	}
+	return StatusOK, results
+}
@@ -0,0 +1,206 @@
+package jobs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"server/db"
+	"server/repository"
+	"server/schemas"
+)
+
+const (
+	batchSize = 100
+	// leaseDuration is how long a claimed job is left to its worker without progress
+	// before workers of other replicas may take it over.
+	leaseDuration = time.Minute
+	// pollInterval is how often the worker looks for jobs enqueued on other replicas
+	// or abandoned by stopped ones.
+	pollInterval = 10 * time.Second
+)
+
+// Worker processes banner deletion jobs one by one in a background goroutine.
+// Job state is kept in the job repository, so it can be queried from any request,
+// and jobs are claimed from it, so replicas sharing it never run the same job.
+type Worker struct {
+	jobs        repository.JobRepository
+	banners     repository.BannerRepository
+	bannerCache db.Cache
+	wake        chan struct{}
+
+	stop     chan struct{}
+	stopOnce sync.Once
+	done     chan struct{}
+}
+
+// errStopped interrupts a job when the worker is stopped. The job is put back to
+// pending and picked up by the next worker.
+var errStopped = errors.New("worker stopped")
+
+func NewWorker(jobs repository.JobRepository, banners repository.BannerRepository, bannerCache db.Cache) *Worker {
+	return &Worker{
+		jobs:        jobs,
+		banners:     banners,
+		bannerCache: bannerCache,
+		wake:        make(chan struct{}, 1),
+		stop:        make(chan struct{}),
+	}
+}
+
+// Start launches the worker goroutine. Jobs left unfinished by a previous run
+// of the server are picked up again.
+func (w *Worker) Start() {
+	w.done = make(chan struct{})
+	go w.work()
+}
+
+// EnqueueBannerDeletion stores a new pending job and wakes the worker up without
+// waiting for it.
+func (w *Worker) EnqueueBannerDeletion(ctx context.Context, featureId int, tagId int) (*schemas.BannerDeletionJob, error) {
+	job := schemas.BannerDeletionJob{FeatureID: featureId, TagID: tagId, Status: schemas.JobPending}
+	if err := w.jobs.Create(ctx, &job); err != nil {
+		return nil, err
+	}
+
+	select {
+	case w.wake <- struct{}{}:
+	default:
+	}
+	return &job, nil
+}
+
+// Stop makes the worker finish the batch in progress and exit, waiting for it
+// until ctx is done.
+func (w *Worker) Stop(ctx context.Context) error {
+	if w.done == nil {
+		return nil
+	}
+
+	w.stopOnce.Do(func() { close(w.stop) })
+	select {
+	case <-w.done:
+		return nil
+	case <-ctx.Done():
+		return fmt.Errorf("error stopping worker: %w", ctx.Err())
+	}
+}
+
+func (w *Worker) work() {
+	defer close(w.done)
+
+	ticker := time.NewTicker(pollInterval)
+	defer ticker.Stop()
+	for {
+		if !w.runClaimable() {
+			return
+		}
+		select {
+		case <-w.stop:
+			return
+		case <-w.wake:
+		case <-ticker.C:
+		}
+	}
+}
+
+// runClaimable runs jobs until there are none to claim. It returns false if the worker
+// was stopped.
+func (w *Worker) runClaimable() bool {
+	ctx := context.Background()
+	for {
+		select {
+		case <-w.stop:
+			return false
+		default:
+		}
+
+		job, err := w.jobs.Claim(ctx, time.Now().Add(leaseDuration))
+		if errors.Is(err, repository.ErrNotFound) {
+			return true
+		} else if err != nil {
+			log.Printf("error claiming job: %v", err)
+			return true
+		}
+
+		err = w.runBannerDeletion(job)
+		if errors.Is(err, errStopped) {
+			log.Printf("banner deletion job %d interrupted by shutdown", job.ID)
+			job.Status = schemas.JobPending
+			if err = w.jobs.SaveClaimed(ctx, job, nil); err != nil {
+				log.Printf("error releasing job %d: %v", job.ID, err)
+			}
+			return false
+		} else if errors.Is(err, repository.ErrClaimLost) {
+			// The lease expired before the progress was saved and another worker took
+			// the job over, so it is left to that worker.
+			log.Printf("banner deletion job %d taken over by another worker", job.ID)
+		} else if err != nil {
+			log.Printf("banner deletion job %d failed: %v", job.ID, err)
+			job.Status = schemas.JobFailed
+			job.Error = err.Error()
+			if err = w.save(ctx, job); err != nil {
+				log.Printf("error updating job %d: %v", job.ID, err)
+			}
+		}
+	}
+}
+
+// save stores the job progress, extending its lease. It returns repository.ErrClaimLost
+// if the job has been taken over by another worker.
+func (w *Worker) save(ctx context.Context, job *schemas.BannerDeletionJob) error {
+	leaseUntil := time.Now().Add(leaseDuration)
+	return w.jobs.SaveClaimed(ctx, job, &leaseUntil)
+}
+
+func (w *Worker) runBannerDeletion(job *schemas.BannerDeletionJob) error {
+	ctx := context.Background()
+	banners, err := w.banners.List(ctx, repository.BannerFilter{FeatureID: job.FeatureID, TagID: job.TagID})
+	if err != nil {
+		return fmt.Errorf("error getting banners: %w", err)
+	}
+
+	// A restarted job only sees banners that are still left, so the progress counts start over.
+	job.Total = len(banners)
+	job.Deleted = 0
+	if err = w.save(ctx, job); err != nil {
+		return fmt.Errorf("error updating job: %w", err)
+	}
+
+	for start := 0; start < len(banners); start += batchSize {
+		select {
+		case <-w.stop:
+			return errStopped
+		default:
+		}
+
+		end := start + batchSize
+		if end > len(banners) {
+			end = len(banners)
+		}
+		batch := make([]*schemas.Banner, 0, end-start)
+		batchIds := make([]uint, 0, end-start)
+		for i := start; i < end; i++ {
+			batch = append(batch, &banners[i])
+			batchIds = append(batchIds, banners[i].ID)
+		}
+
+		if err = w.banners.Delete(ctx, batchIds...); err != nil {
+			return fmt.Errorf("error deleting banners: %w", err)
+		}
+		if err = db.InvalidateBanners(ctx, w.bannerCache, batch...); err != nil {
+			log.Printf("error invalidating banner cache: %v", err)
+		}
+
+		job.Deleted += len(batch)
+		if err = w.save(ctx, job); err != nil {
+			return fmt.Errorf("error updating job: %w", err)
+		}
+	}
+
+	job.Status = schemas.JobDone
+	return w.save(ctx, job)
+}
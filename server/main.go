@@ -1,18 +1,187 @@
 package main
 
 import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"log/slog"
+	"net/http"
+	"os"
+	"os/signal"
+	"sync/atomic"
+	"syscall"
+	"time"
+
 	"github.com/gin-gonic/gin"
 
+	"server/apierror"
+	"server/config"
 	"server/db"
+	"server/health"
+	"server/jobs"
+	"server/metrics"
+	"server/middlewares"
+	"server/openapi"
+	"server/repository"
 	"server/routes"
+	"server/schemas"
+	"server/seed"
+	"server/tracing"
 )
 
 func main() {
-	db.ConnectToDb()
-	db.InitCaches()
+	cfg, err := config.Load()
+	if err != nil {
+		log.Fatal(err)
+	}
+	slog.SetDefault(slog.New(slog.NewJSONHandler(os.Stderr, &slog.HandlerOptions{Level: cfg.Log.LogLevel()})))
+	gin.SetMode(cfg.Server.GinMode)
+	shutdownTracing, err := tracing.Setup(context.Background(), cfg.Tracing)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	db.ConnectToDb(cfg.Database)
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		if err := db.RunMigrateCommand(os.Args[2:]); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+	if err := db.CheckSchemaVersion(context.Background()); err != nil {
+		log.Fatal(err)
+	}
+	db.InitCaches(cfg.Cache)
+	if err := instrument(); err != nil {
+		log.Fatal(err)
+	}
+
+	banners := repository.NewPostgresBannerRepository(db.DB)
+	users := repository.NewPostgresUserRepository(db.DB)
+	if err := seed.Run(context.Background(), users, banners); err != nil {
+		log.Fatal(err)
+	}
+
+	jobRepository := repository.NewPostgresJobRepository(db.DB)
+	worker := jobs.NewWorker(jobRepository, banners, db.BannerCache)
+	worker.Start()
+
+	var jwtVerifier *middlewares.JWTVerifier
+	if cfg.Auth.JWTEnabled() {
+		if jwtVerifier, err = middlewares.NewJWTVerifier([]byte(cfg.Auth.JWTSecret), cfg.Auth.JWKSFile); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	roles := repository.NewPostgresRoleRepository(db.DB)
+	checker := newHealthChecker(roles)
+
+	r := gin.New()
+	r.Use(middlewares.RequestLogger(slog.Default()), apierror.Recovery())
+	routes.SetupRoutes(r, routes.Dependencies{
+		Banners:     banners,
+		Users:       users,
+		Roles:       roles,
+		Jobs:        jobRepository,
+		Worker:      worker,
+		BannerCache: db.BannerCache,
+		UserCache:   db.UserCache,
+		RoleCache:   db.RoleCache,
+		Health:      checker,
+		JWT:         jwtVerifier,
+
+		DisableStaticTokens: cfg.Auth.Mode == config.AuthModeJWT,
+		Validation:          openapi.ValidatorOptions{Requests: cfg.Server.ValidateRequests},
+	})
+
+	serve(cfg.Server, r, checker)
+
+	// Requests are drained by now, so nothing uses the worker, caches or database.
+	ctx, cancel := context.WithTimeout(context.Background(), cfg.Server.ShutdownTimeout)
+	defer cancel()
+	if err := worker.Stop(ctx); err != nil {
+		log.Print(err)
+	}
+	if err := db.CloseCaches(); err != nil {
+		log.Printf("error closing caches: %v", err)
+	}
+	if err := db.Close(); err != nil {
+		log.Printf("error closing database: %v", err)
+	}
+	if err := shutdownTracing(ctx); err != nil {
+		log.Printf("error flushing traces: %v", err)
+	}
+}
+
+// instrument records metrics and traces of database queries and exports cache statistics.
+func instrument() error {
+	return errors.Join(
+		metrics.InstrumentDB(db.DB),
+		tracing.InstrumentDB(db.DB),
+		metrics.RegisterCache("banner", db.BannerCache),
+		metrics.RegisterCache("user", db.UserCache),
+		metrics.RegisterCache("role", db.RoleCache),
+	)
+}
+
+// newHealthChecker checks that the database is reachable and migrated and that the caches
+// are warmed up and reachable. Roles are loaded into the cache by the first successful check.
+func newHealthChecker(roles repository.RoleRepository) *health.Checker {
+	checker := health.NewChecker()
+	checker.Add("database", db.Ping)
+	checker.Add("migrations", db.CheckSchemaVersion)
+
+	var warmed atomic.Bool
+	checker.Add("cache", func(ctx context.Context) error {
+		if !warmed.Load() {
+			if err := middlewares.WarmRoleCache(ctx, roles, db.RoleCache); err != nil {
+				return fmt.Errorf("error warming role cache: %w", err)
+			}
+			warmed.Store(true)
+		}
+		var banner schemas.Banner
+		_, err := db.BannerCache.Get(ctx, "readyz", &banner)
+		return err
+	})
+	return checker
+}
+
+// serve runs the HTTP server until SIGINT or SIGTERM, then stops accepting connections
+// and waits up to the shutdown timeout for in-flight requests. With UnreadyOnShutdown,
+// /readyz fails for ShutdownDelay before that while requests are still served.
+func serve(cfg config.ServerConfig, handler http.Handler, checker *health.Checker) {
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	srv := &http.Server{
+		Addr:         cfg.Addr,
+		Handler:      handler,
+		ReadTimeout:  cfg.ReadTimeout,
+		WriteTimeout: cfg.WriteTimeout,
+		IdleTimeout:  cfg.IdleTimeout,
+	}
+	errs := make(chan error, 1)
+	go func() {
+		errs <- srv.ListenAndServe()
+	}()
 
-	r := gin.Default()
-	routes.SetupRoutes(r)
+	select {
+	case err := <-errs:
+		log.Fatal(err)
+	case <-ctx.Done():
+	}
+	stop()
+	if cfg.UnreadyOnShutdown {
+		checker.SetShuttingDown()
+		log.Printf("shutting down, reporting not ready for %v", cfg.ShutdownDelay)
+		time.Sleep(cfg.ShutdownDelay)
+	}
+	log.Printf("shutting down, draining requests for up to %v", cfg.ShutdownTimeout)
 
-	r.Run()
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), cfg.ShutdownTimeout)
+	defer cancel()
+	if err := srv.Shutdown(shutdownCtx); err != nil {
+		log.Printf("error draining requests: %v", err)
+	}
 }
@@ -0,0 +1,54 @@
+package metrics
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+
+	"server/db"
+)
+
+// cacheCollector reads the statistics the cache keeps anyway on every scrape,
+// so cache lookups don't pay for metrics.
+type cacheCollector struct {
+	cache db.Cache
+
+	hits      *prometheus.Desc
+	misses    *prometheus.Desc
+	evictions *prometheus.Desc
+	entries   *prometheus.Desc
+	bytes     *prometheus.Desc
+}
+
+func newCacheCollector(name string, cache db.Cache) *cacheCollector {
+	labels := prometheus.Labels{"cache": name}
+	return &cacheCollector{
+		cache:     cache,
+		hits:      prometheus.NewDesc("cache_hits_total", "Number of cache lookups that found an entry.", nil, labels),
+		misses:    prometheus.NewDesc("cache_misses_total", "Number of cache lookups that found no entry.", nil, labels),
+		evictions: prometheus.NewDesc("cache_evictions_total", "Number of entries evicted to stay within the cache limits.", nil, labels),
+		entries:   prometheus.NewDesc("cache_entries", "Number of entries in a process-local cache.", nil, labels),
+		bytes:     prometheus.NewDesc("cache_bytes", "Estimated size of the entries in a process-local cache.", nil, labels),
+	}
+}
+
+func (c *cacheCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.hits
+	ch <- c.misses
+	ch <- c.evictions
+	ch <- c.entries
+	ch <- c.bytes
+}
+
+func (c *cacheCollector) Collect(ch chan<- prometheus.Metric) {
+	stats := c.cache.Stats()
+	ch <- prometheus.MustNewConstMetric(c.hits, prometheus.CounterValue, float64(stats.Hits))
+	ch <- prometheus.MustNewConstMetric(c.misses, prometheus.CounterValue, float64(stats.Misses))
+	ch <- prometheus.MustNewConstMetric(c.evictions, prometheus.CounterValue, float64(stats.Evictions))
+	ch <- prometheus.MustNewConstMetric(c.entries, prometheus.GaugeValue, float64(stats.Entries))
+	ch <- prometheus.MustNewConstMetric(c.bytes, prometheus.GaugeValue, float64(stats.Bytes))
+}
+
+// RegisterCache exports the cache statistics labeled with name.
+// Redis caches count only the hits and misses of this process.
+func RegisterCache(name string, cache db.Cache) error {
+	return prometheus.Register(newCacheCollector(name, cache))
+}
@@ -0,0 +1,74 @@
+package metrics
+
+import (
+	"errors"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"gorm.io/gorm"
+)
+
+var dbQueryDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+	Name:    "db_query_duration_seconds",
+	Help:    "Duration of database queries by operation, table and outcome.",
+	Buckets: []float64{.0005, .001, .0025, .005, .01, .025, .05, .1, .25, .5, 1, 2.5},
+}, []string{"operation", "table", "status"})
+
+const queryStartKey = "metrics:query_start"
+
+// InstrumentDB records the duration of every query made through gdb and
+// exports the connection pool statistics.
+func InstrumentDB(gdb *gorm.DB) error {
+	sqlDB, err := gdb.DB()
+	if err != nil {
+		return err
+	}
+	if err = prometheus.Register(collectors.NewDBStatsCollector(sqlDB, "postgres")); err != nil {
+		return err
+	}
+	return gdb.Use(gormPlugin{})
+}
+
+type gormPlugin struct{}
+
+func (gormPlugin) Name() string {
+	return "metrics"
+}
+
+func (gormPlugin) Initialize(gdb *gorm.DB) error {
+	callbacks := gdb.Callback()
+	return errors.Join(
+		callbacks.Create().Before("*").Register("metrics:before_create", startQuery),
+		callbacks.Create().After("*").Register("metrics:after_create", observeQuery("create")),
+		callbacks.Query().Before("*").Register("metrics:before_query", startQuery),
+		callbacks.Query().After("*").Register("metrics:after_query", observeQuery("query")),
+		callbacks.Update().Before("*").Register("metrics:before_update", startQuery),
+		callbacks.Update().After("*").Register("metrics:after_update", observeQuery("update")),
+		callbacks.Delete().Before("*").Register("metrics:before_delete", startQuery),
+		callbacks.Delete().After("*").Register("metrics:after_delete", observeQuery("delete")),
+		callbacks.Row().Before("*").Register("metrics:before_row", startQuery),
+		callbacks.Row().After("*").Register("metrics:after_row", observeQuery("row")),
+		callbacks.Raw().Before("*").Register("metrics:before_raw", startQuery),
+		callbacks.Raw().After("*").Register("metrics:after_raw", observeQuery("raw")),
+	)
+}
+
+func startQuery(tx *gorm.DB) {
+	tx.InstanceSet(queryStartKey, time.Now())
+}
+
+func observeQuery(operation string) func(tx *gorm.DB) {
+	return func(tx *gorm.DB) {
+		start, ok := tx.InstanceGet(queryStartKey)
+		if !ok {
+			return
+		}
+		status := "ok"
+		if tx.Error != nil && !errors.Is(tx.Error, gorm.ErrRecordNotFound) {
+			status = "error"
+		}
+		dbQueryDuration.WithLabelValues(operation, tx.Statement.Table, status).Observe(time.Since(start.(time.Time)).Seconds())
+	}
+}
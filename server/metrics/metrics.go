@@ -0,0 +1,61 @@
+// Package metrics exposes Prometheus metrics of HTTP handlers, caches and the database.
+package metrics
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+var (
+	httpRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "http_requests_total",
+		Help: "Number of HTTP requests by route and status.",
+	}, []string{"method", "route", "status"})
+
+	httpRequestDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "http_request_duration_seconds",
+		Help:    "Latency of HTTP requests by route and status.",
+		Buckets: []float64{.0005, .001, .0025, .005, .01, .025, .05, .1, .25, .5, 1, 2.5},
+	}, []string{"method", "route", "status"})
+)
+
+// unmatchedRoute labels requests that matched no route, so that
+// arbitrary paths don't create new series.
+const unmatchedRoute = "unmatched"
+
+// Middleware records the count and latency of every request under its route pattern.
+// Requests whose handlers panicked are recorded with the 500 the recovery middleware
+// installed before this one answers with.
+func Middleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+		completed := false
+		defer func() {
+			route := c.FullPath()
+			if route == "" {
+				route = unmatchedRoute
+			}
+			code := c.Writer.Status()
+			if !completed && !c.Writer.Written() {
+				code = http.StatusInternalServerError
+			}
+			status := strconv.Itoa(code)
+			httpRequests.WithLabelValues(c.Request.Method, route, status).Inc()
+			httpRequestDuration.WithLabelValues(c.Request.Method, route, status).Observe(time.Since(start).Seconds())
+		}()
+
+		c.Next()
+		completed = true
+	}
+}
+
+// Handler serves all registered metrics in the Prometheus text format.
+func Handler() http.Handler {
+	return promhttp.Handler()
+}
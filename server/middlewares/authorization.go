@@ -1,37 +1,247 @@
 package middlewares
 
 import (
+	"context"
+	"errors"
+	"log"
 	"net/http"
+	"strconv"
+	"strings"
 
 	"github.com/gin-gonic/gin"
-	"github.com/patrickmn/go-cache"
+	"github.com/golang-jwt/jwt/v5"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 
+	"server/apierror"
 	"server/db"
+	"server/repository"
 	"server/schemas"
+	"server/tracing"
 )
 
-func IsAuthorized(needAdmin bool) gin.HandlerFunc {
-	return func(c *gin.Context) {
-		token := c.GetHeader("token")
-		var user schemas.User
-
-		if v, ok := db.UserCache.Get(token); ok {
-			user, ok = v.(schemas.User)
-			if !ok {
-				c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "invalid user cache entry"})
-			}
-		} else {
-			db.DB.Model(&schemas.User{}).First(&user, "token = ?", token)
-			if user.ID == 0 {
-				c.AbortWithStatus(http.StatusUnauthorized)
-			}
+const (
+	// UserKey is the gin context key of the authenticated schemas.User.
+	UserKey = "user"
+	// RoleKey is the gin context key of the user's schemas.Role.
+	RoleKey = "role"
+	// ClaimsKey is the gin context key of *Claims for requests authenticated with a JWT.
+	ClaimsKey = "claims"
+)
+
+// Auth authenticates requests either with a JWT from the Authorization header,
+// validated without touching the database, or with a static token from the token header
+// looked up in the user repository. Permissions come from the user's role.
+type Auth struct {
+	Users     repository.UserRepository
+	Roles     repository.RoleRepository
+	UserCache db.Cache
+	RoleCache db.Cache
+	// JWT is nil when JWT authentication is disabled.
+	JWT *JWTVerifier
+	// DisableStaticTokens makes JWTs the only way to authenticate.
+	DisableStaticTokens bool
+}
+
+// IsAuthorized lets through users having all the permissions, any authenticated user
+// if none are given.
+func (a *Auth) IsAuthorized(permissions ...schemas.Permission) gin.HandlerFunc {
+	return a.authorize(permissions, false)
+}
+
+// IsAuthorizedAny lets through users having at least one of the permissions.
+func (a *Auth) IsAuthorizedAny(permissions ...schemas.Permission) gin.HandlerFunc {
+	return a.authorize(permissions, true)
+}
+
+// HasPermission reports whether the user authenticated by IsAuthorized has the permission.
+func HasPermission(c *gin.Context, permission schemas.Permission) bool {
+	role, ok := c.Get(RoleKey)
+	return ok && role.(*schemas.Role).Has(permission)
+}
+
+// allowed reports whether the role has all the permissions, or any of them with anyOf set.
+func allowed(role *schemas.Role, permissions []schemas.Permission, anyOf bool) bool {
+	for _, permission := range permissions {
+		if role.Has(permission) == anyOf {
+			return anyOf
 		}
+	}
+	return !anyOf
+}
 
-		db.UserCache.Set(token, user, cache.DefaultExpiration)
-		if !user.IsAdmin && needAdmin {
-			c.AbortWithStatus(http.StatusForbidden)
+func (a *Auth) authorize(permissions []schemas.Permission, anyOf bool) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		user, role := a.authenticate(c, permissions, anyOf)
+		if user == nil {
+			return
 		}
 
+		c.Set(UserKey, *user)
+		c.Set(RoleKey, role)
 		c.Next()
 	}
 }
+
+// authenticate returns the user and role, or nil having aborted the request. It runs in
+// its own span, which ends before the handler is called.
+func (a *Auth) authenticate(c *gin.Context, permissions []schemas.Permission, anyOf bool) (*schemas.User, *schemas.Role) {
+	request := c.Request
+	ctx, span := tracing.Tracer().Start(request.Context(), "IsAuthorized")
+	c.Request = request.WithContext(ctx)
+	defer func() {
+		if c.IsAborted() {
+			span.SetAttributes(attribute.Int("auth.status", c.Writer.Status()))
+		}
+		span.End()
+		c.Request = request
+	}()
+
+	var user *schemas.User
+	if token, ok := bearerToken(c); ok && a.JWT != nil {
+		span.SetAttributes(attribute.String("auth.method", "jwt"))
+		user = a.authenticateJWT(c, token)
+	} else if a.DisableStaticTokens {
+		apierror.Respond(c, apierror.New(http.StatusUnauthorized, apierror.CodeBearerTokenRequired, "bearer token required"))
+		return nil, nil
+	} else {
+		span.SetAttributes(attribute.String("auth.method", "token"))
+		user = a.authenticateToken(c)
+	}
+	if user == nil {
+		return nil, nil
+	}
+
+	role, err := a.findRole(c, user.Role)
+	if err != nil {
+		span.RecordError(err)
+		apierror.Respond(c, apierror.Internal("error getting user role: %w", err))
+		return nil, nil
+	}
+	if !allowed(role, permissions, anyOf) {
+		message := "role %q lacks permissions %v"
+		if anyOf {
+			message = "role %q has none of permissions %v"
+		}
+		apierror.Respond(c, apierror.New(http.StatusForbidden, apierror.CodeForbidden, message, role.Name, permissions).
+			With("required_permissions", permissions))
+		return nil, nil
+	}
+	return user, role
+}
+
+// findRole returns the role by name. Users without a role or with a role that
+// doesn't exist get an empty role without permissions.
+func (a *Auth) findRole(c *gin.Context, name string) (*schemas.Role, error) {
+	role := schemas.Role{Name: name}
+	if len(name) == 0 {
+		return &role, nil
+	}
+
+	cached, err := a.RoleCache.Get(c.Request.Context(), name, &role)
+	if err != nil {
+		// The cache is only an optimization, so the role is looked up in the database instead.
+		log.Printf("error reading role cache: %v", err)
+		trace.SpanFromContext(c.Request.Context()).RecordError(err)
+		role, cached = schemas.Role{Name: name}, false
+	}
+	trace.SpanFromContext(c.Request.Context()).SetAttributes(attribute.Bool("auth.role_cache_hit", cached))
+	if cached {
+		return &role, nil
+	}
+
+	found, err := a.Roles.FindByName(c.Request.Context(), name)
+	if errors.Is(err, repository.ErrNotFound) {
+		found = &role
+	} else if err != nil {
+		return nil, err
+	}
+
+	if err = a.RoleCache.Set(c.Request.Context(), name, *found, db.DefaultExpiration); err != nil {
+		log.Printf("error writing role cache: %v", err)
+	}
+	return found, nil
+}
+
+// WarmRoleCache loads every role into the cache, so that the first requests
+// after a start don't all go to the database for them.
+func WarmRoleCache(ctx context.Context, roles repository.RoleRepository, cache db.Cache) error {
+	all, err := roles.List(ctx)
+	if err != nil {
+		return err
+	}
+	for _, role := range all {
+		if err = cache.Set(ctx, role.Name, role, db.DefaultExpiration); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func bearerToken(c *gin.Context) (string, bool) {
+	header := c.GetHeader("Authorization")
+	token, ok := strings.CutPrefix(header, "Bearer ")
+	return token, ok && len(token) > 0
+}
+
+func (a *Auth) authenticateJWT(c *gin.Context, token string) *schemas.User {
+	claims, err := a.JWT.Verify(token)
+	if errors.Is(err, jwt.ErrTokenExpired) {
+		apierror.Respond(c, apierror.New(http.StatusUnauthorized, apierror.CodeTokenExpired, "token expired"))
+		return nil
+	} else if errors.Is(err, errUnknownSigningKey) {
+		apierror.Respond(c, apierror.New(http.StatusUnauthorized, apierror.CodeInvalidToken, "unknown token signing key"))
+		return nil
+	} else if errors.Is(err, jwt.ErrTokenSignatureInvalid) {
+		apierror.Respond(c, apierror.New(http.StatusUnauthorized, apierror.CodeInvalidToken, "invalid token signature"))
+		return nil
+	} else if err != nil {
+		apierror.Respond(c, apierror.New(http.StatusUnauthorized, apierror.CodeInvalidToken, "invalid token: %w", err))
+		return nil
+	}
+
+	user := schemas.User{Role: claims.Role, TagIDs: claims.Tags}
+	if id, err := strconv.ParseUint(claims.Subject, 10, 64); err == nil {
+		user.ID = uint(id)
+	}
+	c.Set(ClaimsKey, claims)
+	return &user
+}
+
+// authenticateToken looks the user up by the hash of the static token, which is also
+// the user cache key, so plaintext tokens are neither stored nor cached.
+func (a *Auth) authenticateToken(c *gin.Context) *schemas.User {
+	token := c.GetHeader("token")
+	if len(token) == 0 {
+		apierror.Respond(c, apierror.New(http.StatusUnauthorized, apierror.CodeUnauthorized, "token required"))
+		return nil
+	}
+	tokenHash := schemas.HashToken(token)
+	var user schemas.User
+
+	cached, err := a.UserCache.Get(c.Request.Context(), tokenHash, &user)
+	if err != nil {
+		// The cache is only an optimization, so the user is looked up in the database instead.
+		log.Printf("error reading user cache: %v", err)
+		trace.SpanFromContext(c.Request.Context()).RecordError(err)
+		cached = false
+	}
+	trace.SpanFromContext(c.Request.Context()).SetAttributes(attribute.Bool("auth.user_cache_hit", cached))
+	if cached {
+		return &user
+	}
+
+	found, err := a.Users.FindByTokenHash(c.Request.Context(), tokenHash)
+	if errors.Is(err, repository.ErrNotFound) {
+		apierror.Respond(c, apierror.New(http.StatusUnauthorized, apierror.CodeUnauthorized, "unknown token"))
+		return nil
+	} else if err != nil {
+		apierror.Respond(c, apierror.Internal("error getting user from database: %w", err))
+		return nil
+	}
+
+	if err = a.UserCache.Set(c.Request.Context(), tokenHash, *found, db.DefaultExpiration); err != nil {
+		log.Printf("error writing user cache: %v", err)
+	}
+	return found
+}
@@ -0,0 +1,115 @@
+package middlewares
+
+import (
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+var errUnknownSigningKey = errors.New("unknown signing key")
+
+// Claims are the JWT claims the service understands on top of the registered ones.
+type Claims struct {
+	jwt.RegisteredClaims
+	Role string  `json:"role"`
+	Tags []int64 `json:"tags"`
+}
+
+// JWTVerifier validates HS256 tokens with a shared secret and RS256 tokens
+// with public keys from a local JWKS file.
+type JWTVerifier struct {
+	hmacSecret []byte
+	rsaKeys    map[string]*rsa.PublicKey
+}
+
+// NewJWTVerifier creates a verifier. Either hmacSecret or jwksFile may be empty
+// to disable the corresponding algorithm.
+func NewJWTVerifier(hmacSecret []byte, jwksFile string) (*JWTVerifier, error) {
+	v := &JWTVerifier{hmacSecret: hmacSecret, rsaKeys: make(map[string]*rsa.PublicKey)}
+	if len(jwksFile) > 0 {
+		data, err := os.ReadFile(jwksFile)
+		if err != nil {
+			return nil, fmt.Errorf("error reading JWKS file: %w", err)
+		}
+		if v.rsaKeys, err = parseJWKS(data); err != nil {
+			return nil, fmt.Errorf("error parsing JWKS file: %w", err)
+		}
+	}
+	return v, nil
+}
+
+// Verify parses the token and checks its signature and expiration. Tokens without exp
+// are rejected since they could not be revoked.
+func (v *JWTVerifier) Verify(token string) (*Claims, error) {
+	var claims Claims
+	_, err := jwt.ParseWithClaims(token, &claims, v.key,
+		jwt.WithValidMethods([]string{"HS256", "RS256"}), jwt.WithExpirationRequired())
+	if err != nil {
+		return nil, err
+	}
+	return &claims, nil
+}
+
+func (v *JWTVerifier) key(token *jwt.Token) (interface{}, error) {
+	switch token.Method.Alg() {
+	case "HS256":
+		if len(v.hmacSecret) == 0 {
+			return nil, errUnknownSigningKey
+		}
+		return v.hmacSecret, nil
+	case "RS256":
+		kid, _ := token.Header["kid"].(string)
+		if key, ok := v.rsaKeys[kid]; ok {
+			return key, nil
+		}
+		if len(kid) == 0 && len(v.rsaKeys) == 1 {
+			for _, key := range v.rsaKeys {
+				return key, nil
+			}
+		}
+		return nil, errUnknownSigningKey
+	default:
+		return nil, errUnknownSigningKey
+	}
+}
+
+type jwks struct {
+	Keys []struct {
+		Kty string `json:"kty"`
+		Kid string `json:"kid"`
+		Use string `json:"use"`
+		N   string `json:"n"`
+		E   string `json:"e"`
+	} `json:"keys"`
+}
+
+func parseJWKS(data []byte) (map[string]*rsa.PublicKey, error) {
+	var set jwks
+	if err := json.Unmarshal(data, &set); err != nil {
+		return nil, err
+	}
+
+	keys := make(map[string]*rsa.PublicKey)
+	for _, k := range set.Keys {
+		if k.Kty != "RSA" || (len(k.Use) > 0 && k.Use != "sig") {
+			continue
+		}
+
+		n, err := base64.RawURLEncoding.DecodeString(k.N)
+		if err != nil {
+			return nil, fmt.Errorf("invalid modulus of key %q: %w", k.Kid, err)
+		}
+		e, err := base64.RawURLEncoding.DecodeString(k.E)
+		if err != nil {
+			return nil, fmt.Errorf("invalid exponent of key %q: %w", k.Kid, err)
+		}
+		keys[k.Kid] = &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}
+	}
+	return keys, nil
+}
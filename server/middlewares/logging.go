@@ -0,0 +1,83 @@
+package middlewares
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"log/slog"
+	"net/http"
+	"regexp"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.opentelemetry.io/otel/trace"
+
+	"server/schemas"
+)
+
+const (
+	// RequestIDHeader carries the request ID in both requests and responses.
+	RequestIDHeader = "X-Request-ID"
+	// RequestIDKey is the gin context key of the request ID.
+	RequestIDKey = "request_id"
+)
+
+// validRequestID limits IDs taken from clients, so they can't forge log lines
+// or grow them without bound.
+var validRequestID = regexp.MustCompile(`^[A-Za-z0-9._:-]{1,128}$`)
+
+// RequestLogger writes a record for every request once it is served. The request ID is
+// taken from X-Request-ID if the client sent a valid one and generated otherwise, and
+// echoed back in the response. Requests failing with 5xx are logged as errors, with 4xx as warnings.
+func RequestLogger(logger *slog.Logger) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+		requestId := c.GetHeader(RequestIDHeader)
+		if !validRequestID.MatchString(requestId) {
+			requestId = newRequestID()
+		}
+		c.Set(RequestIDKey, requestId)
+		c.Header(RequestIDHeader, requestId)
+
+		c.Next()
+
+		status := c.Writer.Status()
+		attrs := []slog.Attr{
+			slog.String("request_id", requestId),
+			slog.String("method", c.Request.Method),
+			slog.String("route", c.FullPath()),
+			slog.String("path", c.Request.URL.Path),
+			slog.Int("status", status),
+			slog.Float64("latency_ms", float64(time.Since(start).Microseconds())/1000),
+			slog.String("client_ip", c.ClientIP()),
+		}
+		// JWT users may have no numeric ID, they are logged without one.
+		if user, ok := c.Get(UserKey); ok && user.(schemas.User).ID != 0 {
+			attrs = append(attrs, slog.Uint64("user_id", uint64(user.(schemas.User).ID)))
+		}
+		if span := trace.SpanContextFromContext(c.Request.Context()); span.IsValid() {
+			attrs = append(attrs, slog.String("trace_id", span.TraceID().String()))
+		}
+		if len(c.Errors) > 0 {
+			attrs = append(attrs, slog.String("error", c.Errors.String()))
+		}
+
+		level := slog.LevelInfo
+		if status >= http.StatusInternalServerError {
+			level = slog.LevelError
+		} else if status >= http.StatusBadRequest {
+			level = slog.LevelWarn
+		}
+		logger.LogAttrs(c.Request.Context(), level, "request", attrs...)
+	}
+}
+
+// RequestID returns the ID RequestLogger assigned to the request, empty without the middleware.
+func RequestID(c *gin.Context) string {
+	return c.GetString(RequestIDKey)
+}
+
+func newRequestID() string {
+	var b [16]byte
+	_, _ = rand.Read(b[:])
+	return hex.EncodeToString(b[:])
+}
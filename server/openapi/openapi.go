@@ -0,0 +1,51 @@
+// Package openapi embeds the OpenAPI 3 document of the API, serves it and validates
+// requests and responses against it.
+package openapi
+
+import (
+	"context"
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/getkin/kin-openapi/openapi3"
+	"github.com/gin-gonic/gin"
+)
+
+//go:embed openapi.yaml
+var spec []byte
+
+// Load parses the embedded document and checks that it is a valid OpenAPI 3 document.
+func Load() (*openapi3.T, error) {
+	loader := openapi3.NewLoader()
+	doc, err := loader.LoadFromData(spec)
+	if err != nil {
+		return nil, err
+	}
+	if err = doc.Validate(context.Background()); err != nil {
+		return nil, err
+	}
+	return doc, nil
+}
+
+// MustLoad is Load panicking on errors, which can only come from a broken build
+// as the document is embedded.
+func MustLoad() *openapi3.T {
+	doc, err := Load()
+	if err != nil {
+		panic(fmt.Errorf("invalid embedded OpenAPI document: %w", err))
+	}
+	return doc
+}
+
+// Handler serves the document as JSON.
+func Handler(doc *openapi3.T) (gin.HandlerFunc, error) {
+	body, err := json.Marshal(doc)
+	if err != nil {
+		return nil, err
+	}
+	return func(c *gin.Context) {
+		c.Data(http.StatusOK, "application/json; charset=utf-8", body)
+	}, nil
+}
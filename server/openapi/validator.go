@@ -0,0 +1,148 @@
+package openapi
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+
+	"github.com/getkin/kin-openapi/openapi3"
+	"github.com/getkin/kin-openapi/openapi3filter"
+	"github.com/getkin/kin-openapi/routers"
+	"github.com/getkin/kin-openapi/routers/gorillamux"
+	"github.com/gin-gonic/gin"
+
+	"server/apierror"
+)
+
+type ValidatorOptions struct {
+	// Requests rejects requests not matching the document with 400 before they reach handlers.
+	Requests bool
+	// Responses replaces responses not matching the document, including ones with
+	// undocumented statuses and responses of undocumented routes, with 500 describing
+	// the mismatch. It buffers every response, so it is meant for tests.
+	Responses bool
+}
+
+// Validator checks requests and responses of routes against the document.
+// Requests that matched no gin route are passed through as is.
+func Validator(doc *openapi3.T, options ValidatorOptions) (gin.HandlerFunc, error) {
+	router, err := gorillamux.NewRouter(doc)
+	if err != nil {
+		return nil, err
+	}
+	filterOptions := &openapi3filter.Options{
+		// Authentication is up to the auth middleware.
+		AuthenticationFunc:    openapi3filter.NoopAuthenticationFunc,
+		IncludeResponseStatus: true,
+	}
+
+	return func(c *gin.Context) {
+		if c.FullPath() == "" || !options.Requests && !options.Responses {
+			c.Next()
+			return
+		}
+
+		route, pathParams, err := router.FindRoute(c.Request)
+		if errors.Is(err, routers.ErrPathNotFound) || errors.Is(err, routers.ErrMethodNotAllowed) {
+			if options.Responses {
+				respondDrift(c, fmt.Errorf("%s %s is not in the OpenAPI document", c.Request.Method, c.FullPath()))
+				return
+			}
+			c.Next()
+			return
+		} else if err != nil {
+			respondDrift(c, err)
+			return
+		}
+
+		input := &openapi3filter.RequestValidationInput{
+			Request:    c.Request,
+			PathParams: pathParams,
+			Route:      route,
+			Options:    filterOptions,
+		}
+		// Handlers bind bodies as JSON whatever the Content-Type, so a missing one is allowed.
+		if c.Request.ContentLength != 0 && len(c.Request.Header.Get("Content-Type")) == 0 {
+			c.Request.Header.Set("Content-Type", "application/json")
+		}
+		if options.Requests {
+			if err := openapi3filter.ValidateRequest(c.Request.Context(), input); err != nil {
+				apiErr := apierror.BadRequest("invalid request: %w", err)
+				var requestErr *openapi3filter.RequestError
+				if errors.As(err, &requestErr) && requestErr.Parameter != nil {
+					apiErr = apiErr.With("parameter", requestErr.Parameter.Name)
+				}
+				apierror.Respond(c, apiErr)
+				return
+			}
+		}
+		if !options.Responses {
+			c.Next()
+			return
+		}
+
+		writer := c.Writer
+		recorder := &responseRecorder{ResponseWriter: writer, status: http.StatusOK}
+		c.Writer = recorder
+		c.Next()
+		c.Writer = writer
+
+		err = openapi3filter.ValidateResponse(c.Request.Context(), &openapi3filter.ResponseValidationInput{
+			RequestValidationInput: input,
+			Status:                 recorder.status,
+			Header:                 writer.Header(),
+			Body:                   io.NopCloser(bytes.NewReader(recorder.body.Bytes())),
+			Options:                filterOptions,
+		})
+		if err != nil {
+			respondDrift(c, fmt.Errorf("response %d does not match the OpenAPI document: %w", recorder.status, err))
+			return
+		}
+		writer.WriteHeader(recorder.status)
+		writer.WriteHeaderNow()
+		_, _ = writer.Write(recorder.body.Bytes())
+	}, nil
+}
+
+// respondDrift reports a mismatch between the handlers and the document.
+func respondDrift(c *gin.Context, err error) {
+	c.Writer.Header().Del("Content-Type")
+	apierror.Respond(c, apierror.New(http.StatusInternalServerError, apierror.CodeContractViolation, "%w", err))
+}
+
+// responseRecorder holds the response back until it is validated.
+type responseRecorder struct {
+	gin.ResponseWriter
+	status int
+	body   bytes.Buffer
+}
+
+func (w *responseRecorder) WriteHeader(code int) {
+	if code > 0 {
+		w.status = code
+	}
+}
+
+func (w *responseRecorder) WriteHeaderNow() {}
+
+func (w *responseRecorder) Write(data []byte) (int, error) {
+	return w.body.Write(data)
+}
+
+func (w *responseRecorder) WriteString(s string) (int, error) {
+	return w.body.WriteString(s)
+}
+
+func (w *responseRecorder) Status() int {
+	return w.status
+}
+
+func (w *responseRecorder) Size() int {
+	return w.body.Len()
+}
+
+func (w *responseRecorder) Written() bool {
+	return false
+}
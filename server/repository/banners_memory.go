@@ -0,0 +1,216 @@
+package repository
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	"server/schemas"
+)
+
+type bannerTagKey struct {
+	tagId     int64
+	featureId int
+}
+
+type memoryBannerRepository struct {
+	mu       sync.RWMutex
+	lastId   uint
+	banners  map[uint]*schemas.Banner
+	tags     map[bannerTagKey]uint
+	versions map[uint][]schemas.BannerVersion
+}
+
+// NewMemoryBannerRepository keeps banners in the process memory. It is meant for tests
+// and local runs without a database.
+func NewMemoryBannerRepository() BannerRepository {
+	return &memoryBannerRepository{
+		banners:  make(map[uint]*schemas.Banner),
+		tags:     make(map[bannerTagKey]uint),
+		versions: make(map[uint][]schemas.BannerVersion),
+	}
+}
+
+func (r *memoryBannerRepository) List(_ context.Context, filter BannerFilter) ([]schemas.Banner, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	banners := make([]schemas.Banner, 0)
+	for _, banner := range r.banners {
+		if filter.FeatureID != 0 && banner.FeatureID != filter.FeatureID {
+			continue
+		}
+		if filter.TagID != 0 && !containsTag(banner.TagIDs, int64(filter.TagID)) {
+			continue
+		}
+		if filter.ActiveAt != nil && !(banner.IsActive && banner.IsScheduledAt(*filter.ActiveAt)) {
+			continue
+		}
+		banners = append(banners, banner.Clone())
+	}
+	sort.Slice(banners, func(i, j int) bool { return banners[i].ID < banners[j].ID })
+
+	if filter.Offset >= len(banners) {
+		return banners[:0], nil
+	}
+	if filter.Offset > 0 {
+		banners = banners[filter.Offset:]
+	}
+	if filter.Limit > 0 && filter.Limit < len(banners) {
+		banners = banners[:filter.Limit]
+	}
+	return banners, nil
+}
+
+func (r *memoryBannerRepository) FindByID(_ context.Context, id uint) (*schemas.Banner, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	banner, ok := r.banners[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	clone := banner.Clone()
+	return &clone, nil
+}
+
+func (r *memoryBannerRepository) FindForUser(_ context.Context, tagId int, featureId int) (*schemas.Banner, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	id, ok := r.tags[bannerTagKey{tagId: int64(tagId), featureId: featureId}]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	clone := r.banners[id].Clone()
+	return &clone, nil
+}
+
+func (r *memoryBannerRepository) Create(_ context.Context, banner *schemas.Banner) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if err := r.checkConflicts(banner, 0); err != nil {
+		return err
+	}
+
+	r.lastId++
+	now := time.Now()
+	banner.ID = r.lastId
+	banner.CreatedAt = now
+	banner.UpdatedAt = now
+	banner.Version = 1
+	r.store(banner)
+	return nil
+}
+
+func (r *memoryBannerRepository) Update(_ context.Context, banner *schemas.Banner) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return r.update(banner)
+}
+
+func (r *memoryBannerRepository) Delete(_ context.Context, ids ...uint) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, id := range ids {
+		if banner, ok := r.banners[id]; ok {
+			r.releaseTags(banner)
+			delete(r.banners, id)
+		}
+	}
+	return nil
+}
+
+func (r *memoryBannerRepository) Versions(_ context.Context, bannerId uint, limit int) ([]schemas.BannerVersion, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	history := r.versions[bannerId]
+	versions := make([]schemas.BannerVersion, 0, len(history))
+	for i := len(history) - 1; i >= 0 && (limit <= 0 || len(versions) < limit); i-- {
+		versions = append(versions, history[i])
+	}
+	return versions, nil
+}
+
+func (r *memoryBannerRepository) FindVersion(_ context.Context, bannerId uint, versionNumber int) (*schemas.BannerVersion, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	for _, version := range r.versions[bannerId] {
+		if version.Version == versionNumber {
+			return &version, nil
+		}
+	}
+	return nil, ErrNotFound
+}
+
+func (r *memoryBannerRepository) RestoreVersion(_ context.Context, banner *schemas.Banner, versionNumber int) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, version := range r.versions[banner.ID] {
+		if version.Version == versionNumber {
+			version.ApplyTo(banner)
+			return r.update(banner)
+		}
+	}
+	return ErrNotFound
+}
+
+func (r *memoryBannerRepository) update(banner *schemas.Banner) error {
+	current, ok := r.banners[banner.ID]
+	if !ok {
+		return ErrNotFound
+	}
+	if err := r.checkConflicts(banner, banner.ID); err != nil {
+		return err
+	}
+
+	r.releaseTags(current)
+	banner.CreatedAt = current.CreatedAt
+	banner.UpdatedAt = time.Now()
+	banner.Version = current.Version + 1
+	r.store(banner)
+	return nil
+}
+
+func (r *memoryBannerRepository) checkConflicts(banner *schemas.Banner, ownId uint) error {
+	for _, tagId := range banner.TagIDs {
+		if id, ok := r.tags[bannerTagKey{tagId: tagId, featureId: banner.FeatureID}]; ok && id != ownId {
+			return &ConflictError{BannerID: id, TagID: tagId, FeatureID: banner.FeatureID}
+		}
+	}
+	return nil
+}
+
+func (r *memoryBannerRepository) store(banner *schemas.Banner) {
+	stored := banner.Clone()
+	r.banners[banner.ID] = &stored
+	for _, tagId := range banner.TagIDs {
+		r.tags[bannerTagKey{tagId: tagId, featureId: banner.FeatureID}] = banner.ID
+	}
+
+	version := schemas.NewBannerVersion(&stored)
+	version.CreatedAt = stored.UpdatedAt
+	r.versions[banner.ID] = append(r.versions[banner.ID], version)
+}
+
+func (r *memoryBannerRepository) releaseTags(banner *schemas.Banner) {
+	for _, tagId := range banner.TagIDs {
+		delete(r.tags, bannerTagKey{tagId: tagId, featureId: banner.FeatureID})
+	}
+}
+
+func containsTag(tagIds []int64, tagId int64) bool {
+	for _, id := range tagIds {
+		if id == tagId {
+			return true
+		}
+	}
+	return false
+}
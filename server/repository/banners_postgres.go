@@ -0,0 +1,228 @@
+package repository
+
+import (
+	"context"
+	"errors"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	"server/schemas"
+)
+
+type postgresBannerRepository struct {
+	db *gorm.DB
+}
+
+func NewPostgresBannerRepository(db *gorm.DB) BannerRepository {
+	return &postgresBannerRepository{db: db}
+}
+
+func (r *postgresBannerRepository) List(ctx context.Context, filter BannerFilter) ([]schemas.Banner, error) {
+	dbQuery := r.db.WithContext(ctx).Model(&schemas.Banner{})
+
+	if filter.FeatureID != 0 {
+		dbQuery = dbQuery.Where("feature_id = ?", filter.FeatureID)
+	}
+	if filter.TagID != 0 {
+		dbQuery = dbQuery.Where("tag_ids @> ARRAY[?]::integer[]", int64(filter.TagID))
+	}
+	if filter.ActiveAt != nil {
+		dbQuery = dbQuery.Where("is_active AND (starts_at IS NULL OR starts_at <= ?) AND (ends_at IS NULL OR ends_at > ?)", *filter.ActiveAt, *filter.ActiveAt)
+	}
+	if filter.Limit != 0 {
+		dbQuery = dbQuery.Limit(filter.Limit)
+	}
+	if filter.Offset != 0 {
+		dbQuery = dbQuery.Offset(filter.Offset)
+	}
+
+	var banners []schemas.Banner
+	err := dbQuery.Order("id").Find(&banners).Error
+	return banners, err
+}
+
+func (r *postgresBannerRepository) FindByID(ctx context.Context, id uint) (*schemas.Banner, error) {
+	var banner schemas.Banner
+	if err := r.db.WithContext(ctx).Model(&schemas.Banner{}).First(&banner, id).Error; err != nil {
+		return nil, translateError(err)
+	}
+	return &banner, nil
+}
+
+func (r *postgresBannerRepository) FindForUser(ctx context.Context, tagId int, featureId int) (*schemas.Banner, error) {
+	var banner schemas.Banner
+	err := r.db.WithContext(ctx).Model(&schemas.Banner{}).
+		Where("tag_ids @> ARRAY[?]::integer[] AND feature_id = ?", tagId, featureId).
+		First(&banner).Error
+	if err != nil {
+		return nil, translateError(err)
+	}
+	return &banner, nil
+}
+
+func (r *postgresBannerRepository) Create(ctx context.Context, banner *schemas.Banner) error {
+	banner.Version = 1
+	err := r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&schemas.Banner{}).Create(banner).Error; err != nil {
+			return err
+		}
+		if err := syncBannerTags(tx, banner); err != nil {
+			return err
+		}
+		return createBannerVersion(tx, banner)
+	})
+	return r.resolveConflict(ctx, banner, err)
+}
+
+func (r *postgresBannerRepository) Update(ctx context.Context, banner *schemas.Banner) error {
+	err := r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		return saveBannerRevision(tx, banner)
+	})
+	return r.resolveConflict(ctx, banner, err)
+}
+
+func (r *postgresBannerRepository) Delete(ctx context.Context, ids ...uint) error {
+	if len(ids) == 0 {
+		return nil
+	}
+
+	return r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("banner_id IN ?", ids).Delete(&schemas.BannerTag{}).Error; err != nil {
+			return err
+		}
+		return tx.Delete(&schemas.Banner{}, ids).Error
+	})
+}
+
+func (r *postgresBannerRepository) Versions(ctx context.Context, bannerId uint, limit int) ([]schemas.BannerVersion, error) {
+	var versions []schemas.BannerVersion
+	err := r.db.WithContext(ctx).Model(&schemas.BannerVersion{}).
+		Where("banner_id = ?", bannerId).Order("version DESC").Limit(limit).
+		Find(&versions).Error
+	return versions, err
+}
+
+func (r *postgresBannerRepository) FindVersion(ctx context.Context, bannerId uint, versionNumber int) (*schemas.BannerVersion, error) {
+	var version schemas.BannerVersion
+	err := r.db.WithContext(ctx).Model(&schemas.BannerVersion{}).
+		Where("banner_id = ? AND version = ?", bannerId, versionNumber).First(&version).Error
+	if err != nil {
+		return nil, translateError(err)
+	}
+	return &version, nil
+}
+
+func (r *postgresBannerRepository) RestoreVersion(ctx context.Context, banner *schemas.Banner, versionNumber int) error {
+	err := r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		var version schemas.BannerVersion
+		err := tx.Model(&schemas.BannerVersion{}).Where("banner_id = ? AND version = ?", banner.ID, versionNumber).First(&version).Error
+		if err != nil {
+			return translateError(err)
+		}
+
+		version.ApplyTo(banner)
+		return saveBannerRevision(tx, banner)
+	})
+	return r.resolveConflict(ctx, banner, err)
+}
+
+func translateError(err error) error {
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return ErrNotFound
+	}
+	return err
+}
+
+func createBannerVersion(tx *gorm.DB, banner *schemas.Banner) error {
+	version := schemas.NewBannerVersion(banner)
+	return tx.Model(&schemas.BannerVersion{}).Create(&version).Error
+}
+
+// saveBannerRevision stores banner as its next revision. The banner row is locked
+// so that concurrent updates can't produce the same version number.
+func saveBannerRevision(tx *gorm.DB, banner *schemas.Banner) error {
+	var current schemas.Banner
+	err := tx.Model(&schemas.Banner{}).Clauses(clause.Locking{Strength: "UPDATE"}).Select("id", "version").First(&current, banner.ID).Error
+	if err != nil {
+		return translateError(err)
+	}
+
+	banner.Version = current.Version + 1
+	if err = tx.Save(banner).Error; err != nil {
+		return err
+	}
+	if err = syncBannerTags(tx, banner); err != nil {
+		return err
+	}
+	return createBannerVersion(tx, banner)
+}
+
+// syncBannerTags replaces the (tag_id, feature_id) pairs claimed by banner.
+// It returns *ConflictError if any pair already belongs to another banner.
+func syncBannerTags(tx *gorm.DB, banner *schemas.Banner) error {
+	if err := findConflict(tx, banner); err != nil {
+		return err
+	}
+
+	if err := tx.Where("banner_id = ?", banner.ID).Delete(&schemas.BannerTag{}).Error; err != nil {
+		return err
+	}
+
+	tags := make([]schemas.BannerTag, 0, len(banner.TagIDs))
+	for _, tagId := range uniqueTagIDs(banner.TagIDs) {
+		tags = append(tags, schemas.BannerTag{BannerID: banner.ID, TagID: tagId, FeatureID: banner.FeatureID})
+	}
+	if len(tags) == 0 {
+		return nil
+	}
+
+	err := tx.Create(&tags).Error
+	if errors.Is(err, gorm.ErrDuplicatedKey) {
+		// Another transaction claimed one of the pairs after the check above. The banner
+		// is found by resolveConflict once this transaction is rolled back.
+		return &ConflictError{FeatureID: banner.FeatureID}
+	}
+	return err
+}
+
+// findConflict returns *ConflictError if any pair of banner belongs to another banner.
+func findConflict(tx *gorm.DB, banner *schemas.Banner) error {
+	var conflict schemas.BannerTag
+	err := tx.Model(&schemas.BannerTag{}).
+		Where("feature_id = ? AND tag_id IN ? AND banner_id <> ?", banner.FeatureID, []int64(banner.TagIDs), banner.ID).
+		First(&conflict).Error
+	if err == nil {
+		return &ConflictError{BannerID: conflict.BannerID, TagID: conflict.TagID, FeatureID: conflict.FeatureID}
+	} else if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return err
+	}
+	return nil
+}
+
+// resolveConflict fills in the banner of a conflict detected by the unique index, which
+// aborts the transaction and so can only be looked up after it is rolled back.
+func (r *postgresBannerRepository) resolveConflict(ctx context.Context, banner *schemas.Banner, err error) error {
+	var conflict *ConflictError
+	if !errors.As(err, &conflict) || conflict.BannerID != 0 {
+		return err
+	}
+	// The other banner might have released the pair since, then the error is kept as is.
+	if resolved := findConflict(r.db.WithContext(ctx), banner); errors.As(resolved, &conflict) {
+		return resolved
+	}
+	return err
+}
+
+func uniqueTagIDs(tagIds []int64) []int64 {
+	seen := make(map[int64]struct{}, len(tagIds))
+	unique := make([]int64, 0, len(tagIds))
+	for _, tagId := range tagIds {
+		if _, ok := seen[tagId]; ok {
+			continue
+		}
+		seen[tagId] = struct{}{}
+		unique = append(unique, tagId)
+	}
+	return unique
+}
@@ -0,0 +1,101 @@
+package repository
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"server/schemas"
+)
+
+type memoryJobRepository struct {
+	mu     sync.RWMutex
+	lastId uint
+	jobs   map[uint]schemas.BannerDeletionJob
+}
+
+func NewMemoryJobRepository() JobRepository {
+	return &memoryJobRepository{jobs: make(map[uint]schemas.BannerDeletionJob)}
+}
+
+func (r *memoryJobRepository) Create(_ context.Context, job *schemas.BannerDeletionJob) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.lastId++
+	job.ID = r.lastId
+	job.CreatedAt = time.Now()
+	job.UpdatedAt = job.CreatedAt
+	r.jobs[job.ID] = *job
+	return nil
+}
+
+func (r *memoryJobRepository) FindByID(_ context.Context, id uint) (*schemas.BannerDeletionJob, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	job, ok := r.jobs[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return &job, nil
+}
+
+func (r *memoryJobRepository) Save(_ context.Context, job *schemas.BannerDeletionJob) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	job.UpdatedAt = time.Now()
+	r.jobs[job.ID] = *job
+	return nil
+}
+
+func (r *memoryJobRepository) Claim(_ context.Context, leaseUntil time.Time) (*schemas.BannerDeletionJob, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	now := time.Now()
+	var claimed *schemas.BannerDeletionJob
+	for id, job := range r.jobs {
+		if claimed != nil && claimed.ID < id || !claimable(&job, now) {
+			continue
+		}
+		job := job
+		claimed = &job
+	}
+	if claimed == nil {
+		return nil, ErrNotFound
+	}
+
+	claimed.Status = schemas.JobRunning
+	claimed.LeaseUntil = &leaseUntil
+	claimed.UpdatedAt = now
+	r.jobs[claimed.ID] = *claimed
+	return claimed, nil
+}
+
+func (r *memoryJobRepository) SaveClaimed(_ context.Context, job *schemas.BannerDeletionJob, leaseUntil *time.Time) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	current, ok := r.jobs[job.ID]
+	if !ok || !sameLease(current.LeaseUntil, job.LeaseUntil) {
+		return ErrClaimLost
+	}
+	job.LeaseUntil = leaseUntil
+	job.UpdatedAt = time.Now()
+	r.jobs[job.ID] = *job
+	return nil
+}
+
+func sameLease(a *time.Time, b *time.Time) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return a.Equal(*b)
+}
+
+func claimable(job *schemas.BannerDeletionJob, now time.Time) bool {
+	return job.Status == schemas.JobPending ||
+		job.Status == schemas.JobRunning && (job.LeaseUntil == nil || job.LeaseUntil.Before(now))
+}
@@ -0,0 +1,85 @@
+package repository
+
+import (
+	"context"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	"server/schemas"
+)
+
+type postgresJobRepository struct {
+	db *gorm.DB
+}
+
+func NewPostgresJobRepository(db *gorm.DB) JobRepository {
+	return &postgresJobRepository{db: db}
+}
+
+func (r *postgresJobRepository) Create(ctx context.Context, job *schemas.BannerDeletionJob) error {
+	return r.db.WithContext(ctx).Model(&schemas.BannerDeletionJob{}).Create(job).Error
+}
+
+func (r *postgresJobRepository) FindByID(ctx context.Context, id uint) (*schemas.BannerDeletionJob, error) {
+	var job schemas.BannerDeletionJob
+	if err := r.db.WithContext(ctx).Model(&schemas.BannerDeletionJob{}).First(&job, id).Error; err != nil {
+		return nil, translateError(err)
+	}
+	return &job, nil
+}
+
+func (r *postgresJobRepository) Save(ctx context.Context, job *schemas.BannerDeletionJob) error {
+	return r.db.WithContext(ctx).Save(job).Error
+}
+
+func (r *postgresJobRepository) Claim(ctx context.Context, leaseUntil time.Time) (*schemas.BannerDeletionJob, error) {
+	var job schemas.BannerDeletionJob
+	err := r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		// Rows claimed by concurrent transactions are skipped rather than waited for.
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE", Options: "SKIP LOCKED"}).
+			Where("status = ? OR status = ? AND (lease_until IS NULL OR lease_until < ?)",
+				schemas.JobPending, schemas.JobRunning, time.Now()).
+			Order("id").First(&job).Error
+		if err != nil {
+			return translateError(err)
+		}
+
+		job.Status = schemas.JobRunning
+		job.LeaseUntil = leaseTime(&leaseUntil)
+		return tx.Save(&job).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &job, nil
+}
+
+func (r *postgresJobRepository) SaveClaimed(ctx context.Context, job *schemas.BannerDeletionJob, leaseUntil *time.Time) error {
+	claimed := *job
+	claimed.LeaseUntil = leaseTime(leaseUntil)
+	claimed.UpdatedAt = time.Now()
+	res := r.db.WithContext(ctx).Model(&schemas.BannerDeletionJob{}).
+		Where("id = ? AND lease_until = ?", job.ID, job.LeaseUntil).
+		Select("status", "total", "deleted", "error", "lease_until", "updated_at").
+		Updates(&claimed)
+	if res.Error != nil {
+		return res.Error
+	}
+	if res.RowsAffected == 0 {
+		return ErrClaimLost
+	}
+	*job = claimed
+	return nil
+}
+
+// leaseTime rounds the lease to the precision of timestamptz, so the value kept by
+// the worker matches the stored one.
+func leaseTime(leaseUntil *time.Time) *time.Time {
+	if leaseUntil == nil {
+		return nil
+	}
+	rounded := leaseUntil.Round(time.Microsecond)
+	return &rounded
+}
@@ -0,0 +1,103 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"server/schemas"
+)
+
+var ErrNotFound = errors.New("not found")
+
+// ErrClaimLost is returned when saving a job whose lease has been taken over.
+var ErrClaimLost = errors.New("job claimed by another worker")
+
+// ConflictError is returned when a banner claims a (tag_id, feature_id) pair
+// that already belongs to another banner. BannerID is zero if the other banner is unknown.
+type ConflictError struct {
+	BannerID  uint
+	TagID     int64
+	FeatureID int
+}
+
+func (e *ConflictError) Error() string {
+	if e.BannerID == 0 {
+		return fmt.Sprintf("tag_ids with feature_id %d are already used by another banner", e.FeatureID)
+	}
+	return fmt.Sprintf("tag_id %d and feature_id %d are already used by banner %d", e.TagID, e.FeatureID, e.BannerID)
+}
+
+// BannerFilter selects banners for listing. Zero fields are not applied.
+type BannerFilter struct {
+	TagID     int
+	FeatureID int
+	// ActiveAt keeps only active banners whose schedule window contains the time.
+	ActiveAt *time.Time
+	Limit    int
+	Offset   int
+}
+
+type BannerRepository interface {
+	List(ctx context.Context, filter BannerFilter) ([]schemas.Banner, error)
+	// FindByID returns ErrNotFound if there is no such banner.
+	FindByID(ctx context.Context, id uint) (*schemas.Banner, error)
+	// FindForUser returns the banner claiming the tag and feature or ErrNotFound.
+	FindForUser(ctx context.Context, tagId int, featureId int) (*schemas.Banner, error)
+	// Create stores a new banner as its first revision.
+	Create(ctx context.Context, banner *schemas.Banner) error
+	// Update stores banner as its next revision.
+	Update(ctx context.Context, banner *schemas.Banner) error
+	Delete(ctx context.Context, ids ...uint) error
+	// Versions returns up to limit latest revisions of the banner, newest first.
+	Versions(ctx context.Context, bannerId uint, limit int) ([]schemas.BannerVersion, error)
+	// FindVersion returns the revision of the banner or ErrNotFound.
+	FindVersion(ctx context.Context, bannerId uint, version int) (*schemas.BannerVersion, error)
+	// RestoreVersion makes the given revision current by storing it as a new one.
+	// It returns ErrNotFound if the banner has no such revision.
+	RestoreVersion(ctx context.Context, banner *schemas.Banner, version int) error
+}
+
+// UserRepository returns users together with the tags they are members of.
+type UserRepository interface {
+	// List returns users ordered by id.
+	List(ctx context.Context, limit int, offset int) ([]schemas.User, error)
+	// FindByID returns ErrNotFound if there is no such user.
+	FindByID(ctx context.Context, id uint) (*schemas.User, error)
+	// FindByTokenHash returns ErrNotFound if no user has a token with the schemas.HashToken.
+	FindByTokenHash(ctx context.Context, tokenHash string) (*schemas.User, error)
+	// Create stores the user along with its tag memberships.
+	Create(ctx context.Context, user *schemas.User) error
+	// Update saves the user's role and token hash. It returns ErrNotFound if there is no such user.
+	Update(ctx context.Context, user *schemas.User) error
+	// AddTags makes the user a member of the tags. It returns ErrNotFound if there is no such user.
+	AddTags(ctx context.Context, userId uint, tagIds ...int64) error
+	// RemoveTag returns ErrNotFound if the user is not a member of the tag.
+	RemoveTag(ctx context.Context, userId uint, tagId int64) error
+}
+
+type RoleRepository interface {
+	List(ctx context.Context) ([]schemas.Role, error)
+	// FindByName returns ErrNotFound if there is no such role.
+	FindByName(ctx context.Context, name string) (*schemas.Role, error)
+	// Save creates the role or replaces the permissions of an existing one.
+	Save(ctx context.Context, role *schemas.Role) error
+	// Delete returns ErrNotFound if there is no such role.
+	Delete(ctx context.Context, name string) error
+}
+
+type JobRepository interface {
+	Create(ctx context.Context, job *schemas.BannerDeletionJob) error
+	// FindByID returns ErrNotFound if there is no such job.
+	FindByID(ctx context.Context, id uint) (*schemas.BannerDeletionJob, error)
+	Save(ctx context.Context, job *schemas.BannerDeletionJob) error
+	// Claim marks the oldest pending job, or running job whose lease has expired, as
+	// running with the lease extended to leaseUntil and returns it. Concurrent claims
+	// never return the same job. It returns ErrNotFound if there is nothing to claim.
+	Claim(ctx context.Context, leaseUntil time.Time) (*schemas.BannerDeletionJob, error)
+	// SaveClaimed saves a claimed job with its lease set to leaseUntil, nil releasing it,
+	// provided the stored lease is still job.LeaseUntil. Otherwise the job has been
+	// taken over by another worker, nothing is saved and ErrClaimLost is returned.
+	SaveClaimed(ctx context.Context, job *schemas.BannerDeletionJob, leaseUntil *time.Time) error
+}
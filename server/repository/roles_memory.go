@@ -0,0 +1,78 @@
+package repository
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/lib/pq"
+
+	"server/schemas"
+)
+
+type memoryRoleRepository struct {
+	mu    sync.RWMutex
+	roles map[string]schemas.Role
+}
+
+// NewMemoryRoleRepository keeps roles in the process memory, starting with the given ones.
+func NewMemoryRoleRepository(roles ...schemas.Role) RoleRepository {
+	r := &memoryRoleRepository{roles: make(map[string]schemas.Role)}
+	for i := range roles {
+		r.Save(context.Background(), &roles[i])
+	}
+	return r
+}
+
+func (r *memoryRoleRepository) List(_ context.Context) ([]schemas.Role, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	roles := make([]schemas.Role, 0, len(r.roles))
+	for _, role := range r.roles {
+		roles = append(roles, role)
+	}
+	sort.Slice(roles, func(i, j int) bool { return roles[i].Name < roles[j].Name })
+	return roles, nil
+}
+
+func (r *memoryRoleRepository) FindByName(_ context.Context, name string) (*schemas.Role, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	role, ok := r.roles[name]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return &role, nil
+}
+
+func (r *memoryRoleRepository) Save(_ context.Context, role *schemas.Role) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	now := time.Now()
+	if current, ok := r.roles[role.Name]; ok {
+		role.CreatedAt = current.CreatedAt
+	} else {
+		role.CreatedAt = now
+	}
+	role.UpdatedAt = now
+
+	stored := *role
+	stored.Permissions = append(pq.StringArray(nil), role.Permissions...)
+	r.roles[role.Name] = stored
+	return nil
+}
+
+func (r *memoryRoleRepository) Delete(_ context.Context, name string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, ok := r.roles[name]; !ok {
+		return ErrNotFound
+	}
+	delete(r.roles, name)
+	return nil
+}
@@ -0,0 +1,54 @@
+package repository
+
+import (
+	"context"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	"server/schemas"
+)
+
+type postgresRoleRepository struct {
+	db *gorm.DB
+}
+
+func NewPostgresRoleRepository(db *gorm.DB) RoleRepository {
+	return &postgresRoleRepository{db: db}
+}
+
+func (r *postgresRoleRepository) List(ctx context.Context) ([]schemas.Role, error) {
+	var roles []schemas.Role
+	err := r.db.WithContext(ctx).Model(&schemas.Role{}).Order("name").Find(&roles).Error
+	return roles, err
+}
+
+func (r *postgresRoleRepository) FindByName(ctx context.Context, name string) (*schemas.Role, error) {
+	var role schemas.Role
+	if err := r.db.WithContext(ctx).Model(&schemas.Role{}).First(&role, "name = ?", name).Error; err != nil {
+		return nil, translateError(err)
+	}
+	return &role, nil
+}
+
+// Save upserts the role, keeping created_at of an existing one, which is read back into role.
+func (r *postgresRoleRepository) Save(ctx context.Context, role *schemas.Role) error {
+	return r.db.WithContext(ctx).Clauses(
+		clause.OnConflict{
+			Columns:   []clause.Column{{Name: "name"}},
+			DoUpdates: clause.AssignmentColumns([]string{"permissions", "updated_at"}),
+		},
+		clause.Returning{Columns: []clause.Column{{Name: "created_at"}}},
+	).Create(role).Error
+}
+
+func (r *postgresRoleRepository) Delete(ctx context.Context, name string) error {
+	res := r.db.WithContext(ctx).Delete(&schemas.Role{}, "name = ?", name)
+	if res.Error != nil {
+		return res.Error
+	}
+	if res.RowsAffected == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
@@ -0,0 +1,159 @@
+package repository
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/lib/pq"
+
+	"server/schemas"
+)
+
+type memoryUserRepository struct {
+	mu     sync.RWMutex
+	lastId uint
+	users  map[uint]*schemas.User
+	// tokens maps token hashes to user ids.
+	tokens map[string]uint
+}
+
+// NewMemoryUserRepository keeps users in the process memory, starting with the given ones.
+func NewMemoryUserRepository(users ...schemas.User) UserRepository {
+	r := &memoryUserRepository{users: make(map[uint]*schemas.User), tokens: make(map[string]uint)}
+	for i := range users {
+		r.create(&users[i])
+	}
+	return r
+}
+
+func (r *memoryUserRepository) List(_ context.Context, limit int, offset int) ([]schemas.User, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	ids := make([]uint, 0, len(r.users))
+	for id := range r.users {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+
+	if offset > len(ids) {
+		offset = len(ids)
+	} else if offset < 0 {
+		offset = 0
+	}
+	ids = ids[offset:]
+	if limit > 0 && limit < len(ids) {
+		ids = ids[:limit]
+	}
+
+	users := make([]schemas.User, 0, len(ids))
+	for _, id := range ids {
+		user, _ := r.find(id)
+		users = append(users, *user)
+	}
+	return users, nil
+}
+
+func (r *memoryUserRepository) FindByID(_ context.Context, id uint) (*schemas.User, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	return r.find(id)
+}
+
+func (r *memoryUserRepository) FindByTokenHash(_ context.Context, tokenHash string) (*schemas.User, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	id, ok := r.tokens[tokenHash]
+	if !ok || len(tokenHash) == 0 {
+		return nil, ErrNotFound
+	}
+	return r.find(id)
+}
+
+func (r *memoryUserRepository) Create(_ context.Context, user *schemas.User) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.create(user)
+	return nil
+}
+
+func (r *memoryUserRepository) Update(_ context.Context, user *schemas.User) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	stored, ok := r.users[user.ID]
+	if !ok {
+		return ErrNotFound
+	}
+
+	delete(r.tokens, stored.TokenHash)
+	stored.Role = user.Role
+	stored.TokenHash = user.TokenHash
+	stored.UpdatedAt = time.Now()
+	r.tokens[stored.TokenHash] = stored.ID
+	return nil
+}
+
+func (r *memoryUserRepository) AddTags(_ context.Context, userId uint, tagIds ...int64) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	user, ok := r.users[userId]
+	if !ok {
+		return ErrNotFound
+	}
+	for _, tagId := range tagIds {
+		if !user.IsMemberOf(tagId) {
+			user.TagIDs = append(user.TagIDs, tagId)
+		}
+	}
+	sort.Slice(user.TagIDs, func(i, j int) bool { return user.TagIDs[i] < user.TagIDs[j] })
+	return nil
+}
+
+func (r *memoryUserRepository) RemoveTag(_ context.Context, userId uint, tagId int64) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	user, ok := r.users[userId]
+	if !ok || !user.IsMemberOf(tagId) {
+		return ErrNotFound
+	}
+
+	tagIds := make(pq.Int64Array, 0, len(user.TagIDs)-1)
+	for _, id := range user.TagIDs {
+		if id != tagId {
+			tagIds = append(tagIds, id)
+		}
+	}
+	user.TagIDs = tagIds
+	return nil
+}
+
+func (r *memoryUserRepository) create(user *schemas.User) {
+	r.lastId++
+	user.ID = r.lastId
+	user.CreatedAt = time.Now()
+	user.UpdatedAt = user.CreatedAt
+
+	stored := *user
+	stored.TagIDs = append(pq.Int64Array(nil), user.TagIDs...)
+	r.users[stored.ID] = &stored
+	r.tokens[stored.TokenHash] = stored.ID
+}
+
+func (r *memoryUserRepository) find(id uint) (*schemas.User, error) {
+	user, ok := r.users[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+
+	clone := *user
+	clone.TagIDs = append(pq.Int64Array(nil), user.TagIDs...)
+	return &clone, nil
+}
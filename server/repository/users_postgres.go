@@ -0,0 +1,127 @@
+package repository
+
+import (
+	"context"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	"server/schemas"
+)
+
+type postgresUserRepository struct {
+	db *gorm.DB
+}
+
+func NewPostgresUserRepository(db *gorm.DB) UserRepository {
+	return &postgresUserRepository{db: db}
+}
+
+func (r *postgresUserRepository) List(ctx context.Context, limit int, offset int) ([]schemas.User, error) {
+	dbQuery := r.db.WithContext(ctx).Model(&schemas.User{})
+	if limit != 0 {
+		dbQuery = dbQuery.Limit(limit)
+	}
+	if offset != 0 {
+		dbQuery = dbQuery.Offset(offset)
+	}
+
+	var users []schemas.User
+	if err := dbQuery.Order("id").Find(&users).Error; err != nil {
+		return nil, err
+	}
+	if len(users) == 0 {
+		return users, nil
+	}
+
+	ids := make([]uint, len(users))
+	byId := make(map[uint]*schemas.User, len(users))
+	for i := range users {
+		ids[i] = users[i].ID
+		byId[users[i].ID] = &users[i]
+	}
+	var userTags []schemas.UserTag
+	err := r.db.WithContext(ctx).Model(&schemas.UserTag{}).Where("user_id IN ?", ids).Order("tag_id").Find(&userTags).Error
+	if err != nil {
+		return nil, err
+	}
+	for _, userTag := range userTags {
+		user := byId[userTag.UserID]
+		user.TagIDs = append(user.TagIDs, userTag.TagID)
+	}
+	return users, nil
+}
+
+func (r *postgresUserRepository) FindByID(ctx context.Context, id uint) (*schemas.User, error) {
+	return r.find(ctx, "id = ?", id)
+}
+
+func (r *postgresUserRepository) FindByTokenHash(ctx context.Context, tokenHash string) (*schemas.User, error) {
+	if len(tokenHash) == 0 {
+		return nil, ErrNotFound
+	}
+	return r.find(ctx, "token_hash = ?", tokenHash)
+}
+
+func (r *postgresUserRepository) find(ctx context.Context, query string, arg interface{}) (*schemas.User, error) {
+	var user schemas.User
+	if err := r.db.WithContext(ctx).Model(&schemas.User{}).First(&user, query, arg).Error; err != nil {
+		return nil, translateError(err)
+	}
+
+	err := r.db.WithContext(ctx).Model(&schemas.UserTag{}).Where("user_id = ?", user.ID).Order("tag_id").Pluck("tag_id", &user.TagIDs).Error
+	if err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
+
+func (r *postgresUserRepository) Create(ctx context.Context, user *schemas.User) error {
+	return r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&schemas.User{}).Create(user).Error; err != nil {
+			return err
+		}
+		return addUserTags(tx, user.ID, user.TagIDs...)
+	})
+}
+
+func (r *postgresUserRepository) Update(ctx context.Context, user *schemas.User) error {
+	res := r.db.WithContext(ctx).Model(user).Select("role", "token_hash").Updates(user)
+	if res.Error != nil {
+		return res.Error
+	}
+	if res.RowsAffected == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (r *postgresUserRepository) AddTags(ctx context.Context, userId uint, tagIds ...int64) error {
+	if _, err := r.FindByID(ctx, userId); err != nil {
+		return err
+	}
+	return addUserTags(r.db.WithContext(ctx), userId, tagIds...)
+}
+
+func (r *postgresUserRepository) RemoveTag(ctx context.Context, userId uint, tagId int64) error {
+	res := r.db.WithContext(ctx).Delete(&schemas.UserTag{}, "user_id = ? AND tag_id = ?", userId, tagId)
+	if res.Error != nil {
+		return res.Error
+	}
+	if res.RowsAffected == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func addUserTags(tx *gorm.DB, userId uint, tagIds ...int64) error {
+	if len(tagIds) == 0 {
+		return nil
+	}
+
+	userTags := make([]schemas.UserTag, len(tagIds))
+	for i, tagId := range tagIds {
+		userTags[i] = schemas.UserTag{UserID: userId, TagID: tagId}
+	}
+	return tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&userTags).Error
+}
@@ -3,14 +3,100 @@ package routes
 import (
 	"github.com/gin-gonic/gin"
 
+	"server/apierror"
 	"server/controllers"
+	"server/db"
+	"server/health"
+	"server/jobs"
+	"server/metrics"
 	"server/middlewares"
+	"server/openapi"
+	"server/repository"
+	"server/schemas"
+	"server/tracing"
 )
 
-func SetupRoutes(r *gin.Engine) {
-	r.GET("/user_banner", middlewares.IsAuthorized(false), controllers.GetUserBanner)
-	r.GET("/banner", middlewares.IsAuthorized(true), controllers.GetBanners)
-	r.POST("/banner", middlewares.IsAuthorized(true), controllers.PostBanner)
-	r.PATCH("/banner/:id", middlewares.IsAuthorized(true), controllers.UpdateBanner)
-	r.DELETE("/banner/:id", middlewares.IsAuthorized(true), controllers.DeleteBanner)
+type Dependencies struct {
+	Banners     repository.BannerRepository
+	Users       repository.UserRepository
+	Roles       repository.RoleRepository
+	Jobs        repository.JobRepository
+	Worker      *jobs.Worker
+	BannerCache db.Cache
+	UserCache   db.Cache
+	RoleCache   db.Cache
+	// Health runs the readiness checks, none when nil.
+	Health *health.Checker
+	// JWT enables authentication with JWTs when not nil.
+	JWT *middlewares.JWTVerifier
+	// DisableStaticTokens rejects requests authenticated with the token header.
+	DisableStaticTokens bool
+	// Validation checks requests and responses against the OpenAPI document.
+	Validation openapi.ValidatorOptions
+}
+
+func SetupRoutes(r *gin.Engine, deps Dependencies) {
+	if deps.Health == nil {
+		deps.Health = health.NewChecker()
+	}
+	h := &controllers.Handler{
+		Banners:     deps.Banners,
+		Users:       deps.Users,
+		Jobs:        deps.Jobs,
+		Roles:       deps.Roles,
+		Worker:      deps.Worker,
+		BannerCache: deps.BannerCache,
+		UserCache:   deps.UserCache,
+		RoleCache:   deps.RoleCache,
+		Health:      deps.Health,
+	}
+	auth := &middlewares.Auth{
+		Users:     deps.Users,
+		Roles:     deps.Roles,
+		UserCache: deps.UserCache,
+		RoleCache: deps.RoleCache,
+		JWT:       deps.JWT,
+
+		DisableStaticTokens: deps.DisableStaticTokens,
+	}
+
+	doc := openapi.MustLoad()
+	validator, err := openapi.Validator(doc, deps.Validation)
+	if err != nil {
+		panic(err)
+	}
+	spec, err := openapi.Handler(doc)
+	if err != nil {
+		panic(err)
+	}
+
+	r.Use(tracing.Middleware(), metrics.Middleware(), validator)
+	r.NoRoute(apierror.NoRoute)
+	r.GET("/openapi.json", spec)
+	r.GET("/metrics", gin.WrapH(metrics.Handler()))
+	r.GET("/healthz", controllers.Healthz)
+	r.GET("/readyz", h.Readyz)
+
+	r.GET("/user_banner", auth.IsAuthorized(), h.GetUserBanner)
+	r.GET("/banner", auth.IsAuthorized(schemas.PermissionReadBanners), h.GetBanners)
+	r.POST("/banner", auth.IsAuthorized(schemas.PermissionCreateBanners), h.PostBanner)
+	r.DELETE("/banner", auth.IsAuthorized(schemas.PermissionDeleteBanners), h.DeleteBanners)
+	r.PATCH("/banner/:id", auth.IsAuthorizedAny(schemas.PermissionEditBanners, schemas.PermissionToggleBanners), h.UpdateBanner)
+	r.DELETE("/banner/:id", auth.IsAuthorized(schemas.PermissionDeleteBanners), h.DeleteBanner)
+	r.GET("/banner/:id/versions", auth.IsAuthorized(schemas.PermissionReadBanners), h.GetBannerVersions)
+	r.PUT("/banner/:id/versions/:version/restore", auth.IsAuthorizedAny(schemas.PermissionEditBanners, schemas.PermissionToggleBanners), h.RestoreBannerVersion)
+	r.GET("/jobs/:id", auth.IsAuthorized(schemas.PermissionReadBanners), h.GetJob)
+
+	r.GET("/roles", auth.IsAuthorized(schemas.PermissionManageRoles), h.GetRoles)
+	r.PUT("/roles/:name", auth.IsAuthorized(schemas.PermissionManageRoles), h.PutRole)
+	r.DELETE("/roles/:name", auth.IsAuthorized(schemas.PermissionManageRoles), h.DeleteRole)
+
+	r.GET("/users", auth.IsAuthorized(schemas.PermissionManageUsers), h.GetUsers)
+	r.POST("/users", auth.IsAuthorized(schemas.PermissionManageUsers), h.PostUser)
+	r.PUT("/users/:id/role", auth.IsAuthorized(schemas.PermissionManageUsers), h.PutUserRole)
+	r.POST("/users/:id/token", auth.IsAuthorized(schemas.PermissionManageUsers), h.PostUserToken)
+	r.DELETE("/users/:id/token", auth.IsAuthorized(schemas.PermissionManageUsers), h.DeleteUserToken)
+	r.GET("/users/:id/tags", auth.IsAuthorized(schemas.PermissionManageUsers), h.GetUserTags)
+	r.PUT("/users/:id/tags/:tag_id", auth.IsAuthorized(schemas.PermissionManageUsers), h.PutUserTag)
+	r.DELETE("/users/:id/tags/:tag_id", auth.IsAuthorized(schemas.PermissionManageUsers), h.DeleteUserTag)
 }
@@ -1,6 +1,8 @@
 package schemas
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
 	"time"
 
 	"github.com/lib/pq"
@@ -9,8 +11,98 @@ import (
 
 type User struct {
 	gorm.Model
-	Token   string `json:"token"`
-	IsAdmin bool   `json:"is_admin"`
+	// TokenHash is the HashToken of the user's static token, empty if the token is revoked.
+	TokenHash string `gorm:"uniqueIndex:idx_users_token_hash,where:token_hash <> ''" json:"-"`
+	// Role is the name of the user's role, empty for users who may only get user banners.
+	Role string `gorm:"index" json:"role"`
+	// TagIDs are the tags the user is a member of, stored in user_tags.
+	TagIDs pq.Int64Array `gorm:"-" json:"tag_ids"`
+}
+
+func (u *User) IsMemberOf(tagId int64) bool {
+	for _, id := range u.TagIDs {
+		if id == tagId {
+			return true
+		}
+	}
+	return false
+}
+
+// HashToken returns the hex-encoded SHA-256 of the token. Tokens are random and long,
+// so a fast hash is enough and lets users be looked up by it.
+func HashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+type UserTag struct {
+	UserID uint  `gorm:"primaryKey" json:"user_id"`
+	TagID  int64 `gorm:"primaryKey;index" json:"tag_id"`
+}
+
+type Permission string
+
+const (
+	PermissionReadBanners   Permission = "banners:read"
+	PermissionCreateBanners Permission = "banners:create"
+	PermissionEditBanners   Permission = "banners:edit"
+	PermissionToggleBanners Permission = "banners:toggle"
+	PermissionDeleteBanners Permission = "banners:delete"
+	PermissionManageRoles   Permission = "roles:manage"
+	PermissionManageUsers   Permission = "users:manage"
+)
+
+var AllPermissions = []Permission{
+	PermissionReadBanners,
+	PermissionCreateBanners,
+	PermissionEditBanners,
+	PermissionToggleBanners,
+	PermissionDeleteBanners,
+	PermissionManageRoles,
+	PermissionManageUsers,
+}
+
+func IsKnownPermission(permission Permission) bool {
+	for _, p := range AllPermissions {
+		if p == permission {
+			return true
+		}
+	}
+	return false
+}
+
+type Role struct {
+	Name        string         `gorm:"primaryKey" json:"name"`
+	CreatedAt   time.Time      `json:"created_at"`
+	UpdatedAt   time.Time      `json:"updated_at"`
+	Permissions pq.StringArray `gorm:"type:text []" json:"permissions"`
+}
+
+func (r *Role) Has(permission Permission) bool {
+	for _, p := range r.Permissions {
+		if Permission(p) == permission {
+			return true
+		}
+	}
+	return false
+}
+
+// DefaultRoles are created on startup unless roles with the same names already exist.
+func DefaultRoles() []Role {
+	permissions := func(ps ...Permission) pq.StringArray {
+		names := make(pq.StringArray, len(ps))
+		for i, p := range ps {
+			names[i] = string(p)
+		}
+		return names
+	}
+
+	return []Role{
+		{Name: "viewer", Permissions: permissions(PermissionReadBanners)},
+		{Name: "editor", Permissions: permissions(PermissionReadBanners, PermissionCreateBanners, PermissionEditBanners)},
+		{Name: "publisher", Permissions: permissions(PermissionReadBanners, PermissionToggleBanners)},
+		{Name: "admin", Permissions: permissions(AllPermissions...)},
+	}
 }
 
 type Banner struct {
@@ -22,4 +114,101 @@ type Banner struct {
 	IsActive  bool           `json:"is_active"`
 	TagIDs    pq.Int64Array  `gorm:"type:integer []" json:"tag_ids"`
 	Content   JSONB          `gorm:"type:jsonb" json:"content"`
+	StartsAt  *time.Time     `json:"starts_at,omitempty"`
+	EndsAt    *time.Time     `json:"ends_at,omitempty"`
+	Version   int            `gorm:"not null;default:1" json:"version"`
+}
+
+// Clone copies the banner so that its tags and content can be changed
+// without affecting the original.
+func (b *Banner) Clone() Banner {
+	clone := *b
+	clone.TagIDs = append(pq.Int64Array(nil), b.TagIDs...)
+	if b.Content != nil {
+		clone.Content = make(JSONB, len(b.Content))
+		for k, v := range b.Content {
+			clone.Content[k] = v
+		}
+	}
+	return clone
+}
+
+// IsScheduledAt reports whether t falls into the banner's [starts_at, ends_at) window.
+// Missing bounds are treated as open.
+func (b *Banner) IsScheduledAt(t time.Time) bool {
+	if b.StartsAt != nil && t.Before(*b.StartsAt) {
+		return false
+	}
+	if b.EndsAt != nil && !t.Before(*b.EndsAt) {
+		return false
+	}
+	return true
+}
+
+type BannerTag struct {
+	BannerID  uint  `gorm:"primaryKey"`
+	TagID     int64 `gorm:"primaryKey;uniqueIndex:idx_banner_tags_tag_feature"`
+	FeatureID int   `gorm:"uniqueIndex:idx_banner_tags_tag_feature"`
+}
+
+type BannerVersion struct {
+	ID        uint          `gorm:"primaryKey" json:"-"`
+	BannerID  uint          `gorm:"uniqueIndex:idx_banner_versions_banner_version" json:"banner_id"`
+	Version   int           `gorm:"uniqueIndex:idx_banner_versions_banner_version" json:"version"`
+	CreatedAt time.Time     `json:"created_at"`
+	FeatureID int           `json:"feature_id"`
+	IsActive  bool          `json:"is_active"`
+	TagIDs    pq.Int64Array `gorm:"type:integer []" json:"tag_ids"`
+	Content   JSONB         `gorm:"type:jsonb" json:"content"`
+	StartsAt  *time.Time    `json:"starts_at,omitempty"`
+	EndsAt    *time.Time    `json:"ends_at,omitempty"`
+}
+
+func NewBannerVersion(banner *Banner) BannerVersion {
+	return BannerVersion{
+		BannerID:  banner.ID,
+		Version:   banner.Version,
+		FeatureID: banner.FeatureID,
+		IsActive:  banner.IsActive,
+		TagIDs:    banner.TagIDs,
+		Content:   banner.Content,
+		StartsAt:  banner.StartsAt,
+		EndsAt:    banner.EndsAt,
+	}
+}
+
+// ApplyTo copies the revision's fields into banner, keeping its identity and version.
+func (v *BannerVersion) ApplyTo(banner *Banner) {
+	banner.FeatureID = v.FeatureID
+	banner.IsActive = v.IsActive
+	banner.TagIDs = v.TagIDs
+	banner.Content = v.Content
+	banner.StartsAt = v.StartsAt
+	banner.EndsAt = v.EndsAt
+}
+
+type JobStatus string
+
+const (
+	JobPending JobStatus = "pending"
+	JobRunning JobStatus = "running"
+	JobDone    JobStatus = "done"
+	JobFailed  JobStatus = "failed"
+)
+
+// BannerDeletionJob tracks deletion of every banner matching FeatureID and TagID.
+// Zero FeatureID or TagID means the filter is not set.
+type BannerDeletionJob struct {
+	ID        uint      `gorm:"primaryKey" json:"job_id"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+	FeatureID int       `json:"feature_id,omitempty"`
+	TagID     int       `json:"tag_id,omitempty"`
+	Status    JobStatus `gorm:"index" json:"status"`
+	Total     int       `json:"total"`
+	Deleted   int       `json:"deleted"`
+	Error     string    `json:"error,omitempty"`
+	// LeaseUntil is when a running job may be taken over from a worker that stopped
+	// making progress on it.
+	LeaseUntil *time.Time `json:"-"`
 }
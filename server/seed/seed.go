@@ -0,0 +1,203 @@
+// Package seed fills the storage with users and sample banners described in
+// a YAML or JSON document. Seeding is idempotent, so it can run on every start.
+package seed
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+
+	"gopkg.in/yaml.v3"
+
+	"server/repository"
+	"server/schemas"
+)
+
+// Data is the seed document. Tags and features have no tables of their own,
+// they are seeded by the memberships and banners referring to them.
+type Data struct {
+	Users   []User   `yaml:"users"`
+	Banners []Banner `yaml:"banners"`
+}
+
+// User is identified by its token, which is stored hashed.
+type User struct {
+	Token string  `yaml:"token"`
+	Role  string  `yaml:"role"`
+	Tags  []int64 `yaml:"tags"`
+}
+
+// Banner is identified by the feature and its first tag.
+type Banner struct {
+	FeatureID int                    `yaml:"feature_id"`
+	TagIDs    []int64                `yaml:"tag_ids"`
+	Content   map[string]interface{} `yaml:"content"`
+	IsActive  bool                   `yaml:"is_active"`
+}
+
+// Default is seeded in the dev and test profiles when no document is given.
+func Default() *Data {
+	return &Data{
+		Users: []User{
+			{Token: "user_token", Tags: []int64{1, 2, 3}},
+			{Token: "admin_token", Role: "admin"},
+		},
+		Banners: []Banner{
+			{
+				FeatureID: 1,
+				TagIDs:    []int64{1},
+				Content:   map[string]interface{}{"title": "Sample banner", "text": "Seeded for development", "url": "https://example.com"},
+				IsActive:  true,
+			},
+		},
+	}
+}
+
+// Enabled reports whether seeding should run. SEED_ENABLED overrides the default,
+// which is to seed only when APP_ENV is dev or test.
+func Enabled() (bool, error) {
+	if value := os.Getenv("SEED_ENABLED"); len(value) > 0 {
+		enabled, err := strconv.ParseBool(value)
+		if err != nil {
+			return false, fmt.Errorf("invalid SEED_ENABLED: %w", err)
+		}
+		return enabled, nil
+	}
+
+	env := os.Getenv("APP_ENV")
+	return env == "dev" || env == "test", nil
+}
+
+// Load reads the document from SEED_DATA, then from the file at SEED_FILE,
+// falling back to Default.
+func Load() (*Data, error) {
+	if data := os.Getenv("SEED_DATA"); len(data) > 0 {
+		return Parse([]byte(data))
+	}
+	if file := os.Getenv("SEED_FILE"); len(file) > 0 {
+		raw, err := os.ReadFile(file)
+		if err != nil {
+			return nil, fmt.Errorf("error reading seed file: %w", err)
+		}
+		return Parse(raw)
+	}
+	return Default(), nil
+}
+
+// Parse decodes a YAML document, JSON being accepted as a subset of YAML.
+func Parse(raw []byte) (*Data, error) {
+	var data Data
+	decoder := yaml.NewDecoder(bytes.NewReader(raw))
+	decoder.KnownFields(true)
+	if err := decoder.Decode(&data); err != nil {
+		return nil, fmt.Errorf("invalid seed document: %w", err)
+	}
+
+	for i, user := range data.Users {
+		if len(user.Token) == 0 {
+			return nil, fmt.Errorf("invalid seed document: users[%d] has no token", i)
+		}
+	}
+	for i, banner := range data.Banners {
+		if banner.FeatureID == 0 || len(banner.TagIDs) == 0 {
+			return nil, fmt.Errorf("invalid seed document: banners[%d] should have feature_id and tag_ids", i)
+		}
+	}
+	return &data, nil
+}
+
+// Run seeds the document from Load if seeding is enabled.
+func Run(ctx context.Context, users repository.UserRepository, banners repository.BannerRepository) error {
+	enabled, err := Enabled()
+	if err != nil {
+		return err
+	}
+	if !enabled {
+		log.Printf("seeding is disabled")
+		return nil
+	}
+
+	data, err := Load()
+	if err != nil {
+		return err
+	}
+	return Apply(ctx, data, users, banners)
+}
+
+// Apply creates the users and banners missing from the storage and updates the
+// existing ones to match the document. Memberships are only added, never removed.
+func Apply(ctx context.Context, data *Data, users repository.UserRepository, banners repository.BannerRepository) error {
+	for _, user := range data.Users {
+		if err := applyUser(ctx, users, user); err != nil {
+			return fmt.Errorf("error seeding user with role %q: %w", user.Role, err)
+		}
+	}
+	for _, banner := range data.Banners {
+		if err := applyBanner(ctx, banners, banner); err != nil {
+			return fmt.Errorf("error seeding banner for feature %d: %w", banner.FeatureID, err)
+		}
+	}
+	return nil
+}
+
+func applyUser(ctx context.Context, users repository.UserRepository, seeded User) error {
+	tokenHash := schemas.HashToken(seeded.Token)
+	user, err := users.FindByTokenHash(ctx, tokenHash)
+	if errors.Is(err, repository.ErrNotFound) {
+		return users.Create(ctx, &schemas.User{TokenHash: tokenHash, Role: seeded.Role, TagIDs: seeded.Tags})
+	} else if err != nil {
+		return err
+	}
+
+	if user.Role != seeded.Role {
+		user.Role = seeded.Role
+		if err = users.Update(ctx, user); err != nil {
+			return err
+		}
+	}
+	return users.AddTags(ctx, user.ID, seeded.Tags...)
+}
+
+func applyBanner(ctx context.Context, banners repository.BannerRepository, seeded Banner) error {
+	banner, err := banners.FindForUser(ctx, int(seeded.TagIDs[0]), seeded.FeatureID)
+	if errors.Is(err, repository.ErrNotFound) {
+		return banners.Create(ctx, &schemas.Banner{
+			FeatureID: seeded.FeatureID,
+			TagIDs:    seeded.TagIDs,
+			Content:   seeded.Content,
+			IsActive:  seeded.IsActive,
+		})
+	} else if err != nil {
+		return err
+	}
+
+	same, err := sameBanner(banner, seeded)
+	if err != nil || same {
+		return err
+	}
+	banner.TagIDs = seeded.TagIDs
+	banner.Content = seeded.Content
+	banner.IsActive = seeded.IsActive
+	return banners.Update(ctx, banner)
+}
+
+// sameBanner compares contents by their JSON, as numbers read from the database
+// and from the document have different types.
+func sameBanner(banner *schemas.Banner, seeded Banner) (bool, error) {
+	stored, err := json.Marshal(banner.Content)
+	if err != nil {
+		return false, err
+	}
+	wanted, err := json.Marshal(seeded.Content)
+	if err != nil {
+		return false, err
+	}
+	return bytes.Equal(stored, wanted) &&
+		banner.IsActive == seeded.IsActive &&
+		fmt.Sprint([]int64(banner.TagIDs)) == fmt.Sprint(seeded.TagIDs), nil
+}
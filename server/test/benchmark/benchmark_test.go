@@ -0,0 +1,255 @@
+// Package benchmark_test measures the latency of banner lookups over a large number
+// of banners. It seeds Postgres when DB_URL is set and the in-memory repositories
+// otherwise:
+//
+//	go test -run '^$' -bench . -benchtime 2000x ./test/benchmark
+//
+// BENCH_BANNERS sets the number of seeded banners, 100000 by default.
+package benchmark_test
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sort"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"server/config"
+	"server/db"
+	"server/jobs"
+	"server/repository"
+	"server/routes"
+	"server/schemas"
+	"server/seed"
+)
+
+// Seeded banners get features starting from featureBase, above the ids random tests use.
+// Banner i has feature featureBase + i % features and three tags of group i / features,
+// so every (tag, feature) pair belongs to one banner.
+const (
+	featureBase = 3_000_000_000
+	features    = 1000
+)
+
+var (
+	setupOnce sync.Once
+	router    *gin.Engine
+	banners   int
+)
+
+func setup(b *testing.B) {
+	setupOnce.Do(func() {
+		banners = 100000
+		if value := os.Getenv("BENCH_BANNERS"); len(value) > 0 {
+			var err error
+			if banners, err = strconv.Atoi(value); err != nil {
+				b.Fatalf("invalid BENCH_BANNERS: %v", err)
+			}
+		}
+
+		cfg, err := config.Load()
+		if err != nil {
+			b.Fatal(err)
+		}
+		gin.SetMode(gin.ReleaseMode)
+		db.InitCaches(cfg.Cache)
+
+		var deps routes.Dependencies
+		if len(cfg.Database.URL) > 0 {
+			db.ConnectToDb(cfg.Database)
+			if err := db.MigrateUp(); err != nil {
+				b.Fatal(err)
+			}
+			deps = routes.Dependencies{
+				Banners: repository.NewPostgresBannerRepository(db.DB),
+				Users:   repository.NewPostgresUserRepository(db.DB),
+				Roles:   repository.NewPostgresRoleRepository(db.DB),
+				Jobs:    repository.NewPostgresJobRepository(db.DB),
+			}
+			seedPostgres(b)
+		} else {
+			deps = routes.Dependencies{
+				Banners: repository.NewMemoryBannerRepository(),
+				Users:   repository.NewMemoryUserRepository(),
+				Roles:   repository.NewMemoryRoleRepository(schemas.DefaultRoles()...),
+				Jobs:    repository.NewMemoryJobRepository(),
+			}
+			seedMemory(b, deps.Banners)
+		}
+		if err := seed.Apply(context.Background(), seed.Default(), deps.Users, deps.Banners); err != nil {
+			b.Fatal(err)
+		}
+		deps.BannerCache = db.BannerCache
+		deps.UserCache = db.UserCache
+		deps.RoleCache = db.RoleCache
+		deps.Worker = jobs.NewWorker(deps.Jobs, deps.Banners, deps.BannerCache)
+
+		router = gin.New()
+		routes.SetupRoutes(router, deps)
+	})
+	if router == nil {
+		b.Fatal("benchmark setup failed")
+	}
+}
+
+func bannerFeature(i int) int {
+	return featureBase + i%features
+}
+
+func bannerTags(i int) []int64 {
+	group := int64(i / features)
+	return []int64{group*3 + 1, group*3 + 2, group*3 + 3}
+}
+
+func seedMemory(b *testing.B, repo repository.BannerRepository) {
+	for i := 0; i < banners; i++ {
+		banner := schemas.Banner{
+			FeatureID: bannerFeature(i),
+			TagIDs:    bannerTags(i),
+			IsActive:  true,
+			Content:   schemas.JSONB{"title": fmt.Sprintf("bench %d", i)},
+		}
+		if err := repo.Create(context.Background(), &banner); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// seedPostgres inserts the banners with plain SQL, as creating them one by one
+// through the repository takes minutes. Banners seeded by an earlier run are reused.
+func seedPostgres(b *testing.B) {
+	var seeded int
+	if err := db.DB.Raw("SELECT count(*) FROM banners WHERE feature_id >= ? AND deleted_at IS NULL", featureBase).Scan(&seeded).Error; err != nil {
+		b.Fatal(err)
+	}
+
+	if seeded < banners {
+		start := time.Now()
+		err := db.DB.Exec(`INSERT INTO banners (created_at, updated_at, feature_id, is_active, tag_ids, content, version)
+			SELECT now(), now(), ? + i % ?, true, ARRAY[(i / ?) * 3 + 1, (i / ?) * 3 + 2, (i / ?) * 3 + 3],
+				jsonb_build_object('title', 'bench ' || i), 1
+			FROM generate_series(?, ? - 1) AS i`,
+			featureBase, features, features, features, features, seeded, banners).Error
+		if err != nil {
+			b.Fatal(err)
+		}
+		err = db.DB.Exec(`INSERT INTO banner_tags (banner_id, tag_id, feature_id)
+			SELECT b.id, t.tag_id, b.feature_id FROM banners b, unnest(b.tag_ids) AS t(tag_id)
+			WHERE b.feature_id >= ? AND b.deleted_at IS NULL
+			ON CONFLICT DO NOTHING`, featureBase).Error
+		if err != nil {
+			b.Fatal(err)
+		}
+		b.Logf("seeded %d banners in %v", banners-seeded, time.Since(start))
+	}
+	if err := db.DB.Exec("ANALYZE banners").Error; err != nil {
+		b.Fatal(err)
+	}
+
+	for _, query := range []string{
+		fmt.Sprintf("SELECT * FROM banners WHERE tag_ids @> ARRAY[2]::integer[] AND feature_id = %d AND deleted_at IS NULL LIMIT 1", featureBase),
+		"SELECT * FROM banners WHERE tag_ids @> ARRAY[2]::integer[] AND deleted_at IS NULL ORDER BY id LIMIT 10",
+		fmt.Sprintf("SELECT * FROM banners WHERE feature_id = %d AND deleted_at IS NULL ORDER BY id LIMIT 10", featureBase),
+	} {
+		var plan []string
+		if err := db.DB.Raw("EXPLAIN " + query).Scan(&plan).Error; err != nil {
+			b.Fatal(err)
+		}
+		b.Logf("%s\n%s", query, joinLines(plan))
+	}
+}
+
+func joinLines(lines []string) string {
+	joined := ""
+	for _, line := range lines {
+		joined += "  " + line + "\n"
+	}
+	return joined
+}
+
+// measure serves b.N requests made by newRequest and reports the p50 and p99 latencies.
+func measure(b *testing.B, newRequest func(i int) *http.Request) {
+	b.Helper()
+	latencies := make([]time.Duration, b.N)
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		req := newRequest(i)
+		w := httptest.NewRecorder()
+		start := time.Now()
+		router.ServeHTTP(w, req)
+		latencies[i] = time.Since(start)
+		if w.Code != http.StatusOK {
+			b.Fatalf("%s: unexpected status %d: %s", req.URL, w.Code, w.Body.String())
+		}
+	}
+	b.StopTimer()
+
+	sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+	b.ReportMetric(float64(percentile(latencies, 50).Microseconds()), "p50-us")
+	b.ReportMetric(float64(percentile(latencies, 99).Microseconds()), "p99-us")
+}
+
+func percentile(sorted []time.Duration, p int) time.Duration {
+	return sorted[(len(sorted)-1)*p/100]
+}
+
+func newRequest(b *testing.B, path string) *http.Request {
+	req, err := http.NewRequest(http.MethodGet, path, nil)
+	if err != nil {
+		b.Fatal(err)
+	}
+	req.Header.Set("token", "admin_token")
+	return req
+}
+
+func BenchmarkUserBanner(b *testing.B) {
+	setup(b)
+
+	for _, useLastRevision := range []bool{false, true} {
+		name := "cached"
+		if useLastRevision {
+			name = "last_revision"
+		}
+		b.Run(name, func(b *testing.B) {
+			measure(b, func(int) *http.Request {
+				i := rand.Intn(banners)
+				tags := bannerTags(i)
+				path := fmt.Sprintf("/user_banner?tag_id=%d&feature_id=%d&use_last_revision=%t",
+					tags[rand.Intn(len(tags))], bannerFeature(i), useLastRevision)
+				return newRequest(b, path)
+			})
+		})
+	}
+}
+
+func BenchmarkListBanners(b *testing.B) {
+	setup(b)
+
+	b.Run("by_tag", func(b *testing.B) {
+		measure(b, func(int) *http.Request {
+			i := rand.Intn(banners)
+			return newRequest(b, fmt.Sprintf("/banner?tag_id=%d&limit=10", bannerTags(i)[0]))
+		})
+	})
+	b.Run("by_feature", func(b *testing.B) {
+		measure(b, func(int) *http.Request {
+			i := rand.Intn(banners)
+			return newRequest(b, fmt.Sprintf("/banner?feature_id=%d&limit=10", bannerFeature(i)))
+		})
+	})
+	b.Run("by_tag_and_feature", func(b *testing.B) {
+		measure(b, func(int) *http.Request {
+			i := rand.Intn(banners)
+			return newRequest(b, fmt.Sprintf("/banner?tag_id=%d&feature_id=%d", bannerTags(i)[0], bannerFeature(i)))
+		})
+	})
+}
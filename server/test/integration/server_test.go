@@ -2,28 +2,153 @@ package integration_test
 
 import (
 	"bytes"
+	"context"
+	crand "crypto/rand"
+	"crypto/rsa"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"github.com/getkin/kin-openapi/openapi3"
 	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
 	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"log/slog"
 	"math/rand"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
 	"reflect"
+	"regexp"
+	"server/apierror"
+	"server/config"
 	"server/db"
+	"server/health"
+	"server/jobs"
+	"server/metrics"
+	"server/middlewares"
+	"server/openapi"
+	"server/repository"
 	"server/routes"
 	"server/schemas"
+	"server/seed"
+	"server/tracing"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 )
 
 var router *gin.Engine
 
+// unvalidatedRouter shares the router's dependencies but, as by default in production,
+// doesn't validate requests, so handlers get bodies the OpenAPI document rejects.
+var unvalidatedRouter *gin.Engine
+
+// users lets tests look up the seeded users' ids.
+var users repository.UserRepository
+
+const jwtSecret = "test_secret"
+
+// requestLog collects the records of the router's request logger.
+var requestLog syncBuffer
+
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+// records returns the logged records of the request with the ID.
+func (b *syncBuffer) records(t *testing.T, requestId string) []map[string]interface{} {
+	t.Helper()
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	var records []map[string]interface{}
+	for _, line := range bytes.Split(b.buf.Bytes(), []byte("\n")) {
+		if len(line) == 0 {
+			continue
+		}
+		var record map[string]interface{}
+		require.NoError(t, json.Unmarshal(line, &record))
+		if record["request_id"] == requestId {
+			records = append(records, record)
+		}
+	}
+	return records
+}
+
+// init runs the suite against Postgres when DB_URL is set and against
+// the in-memory repositories otherwise.
 func init() {
-	db.ConnectToDb()
-	db.InitCaches()
+	cfg, err := config.Load()
+	if err != nil {
+		panic(err)
+	}
+	db.InitCaches(cfg.Cache)
+	if _, err := tracing.Setup(context.Background(), cfg.Tracing); err != nil {
+		panic(err)
+	}
+
+	var deps routes.Dependencies
+	if len(cfg.Database.URL) > 0 {
+		db.ConnectToDb(cfg.Database)
+		if err := db.MigrateUp(); err != nil {
+			panic(err)
+		}
+		if err := errors.Join(metrics.InstrumentDB(db.DB), tracing.InstrumentDB(db.DB)); err != nil {
+			panic(err)
+		}
+		deps = routes.Dependencies{
+			Banners: repository.NewPostgresBannerRepository(db.DB),
+			Users:   repository.NewPostgresUserRepository(db.DB),
+			Roles:   repository.NewPostgresRoleRepository(db.DB),
+			Jobs:    repository.NewPostgresJobRepository(db.DB),
+		}
+	} else {
+		deps = routes.Dependencies{
+			Banners: repository.NewMemoryBannerRepository(),
+			Users:   repository.NewMemoryUserRepository(),
+			Roles:   repository.NewMemoryRoleRepository(schemas.DefaultRoles()...),
+			Jobs:    repository.NewMemoryJobRepository(),
+		}
+	}
+	if err := seed.Apply(context.Background(), seed.Default(), deps.Users, deps.Banners); err != nil {
+		panic(err)
+	}
+	users = deps.Users
+	deps.BannerCache = db.BannerCache
+	deps.UserCache = db.UserCache
+	deps.RoleCache = db.RoleCache
+	if err := metrics.RegisterCache("banner", db.BannerCache); err != nil {
+		panic(err)
+	}
+	if err := metrics.RegisterCache("user", db.UserCache); err != nil {
+		panic(err)
+	}
+	deps.JWT, _ = middlewares.NewJWTVerifier([]byte(jwtSecret), "")
+	deps.Worker = jobs.NewWorker(deps.Jobs, deps.Banners, deps.BannerCache)
+	deps.Worker.Start()
+	// Responses drifting from the OpenAPI document turn into 500 and fail the tests.
+	deps.Validation = openapi.ValidatorOptions{Requests: true, Responses: true}
 
-	router = gin.Default()
-	routes.SetupRoutes(router)
+	router = gin.New()
+	router.Use(middlewares.RequestLogger(slog.New(slog.NewJSONHandler(&requestLog, nil))), apierror.Recovery())
+	routes.SetupRoutes(router, deps)
+
+	deps.Validation = openapi.ValidatorOptions{}
+	unvalidatedRouter = gin.New()
+	unvalidatedRouter.Use(apierror.Recovery())
+	routes.SetupRoutes(unvalidatedRouter, deps)
 }
 
 type bannerRequest struct {
@@ -69,6 +194,19 @@ func addBanner(t *testing.T, bannerJSON []byte) (ID int) {
 	return response["banner_id"]
 }
 
+// decodeError decodes the error envelope of the response.
+func decodeError(t *testing.T, w *httptest.ResponseRecorder) apierror.Error {
+	t.Helper()
+
+	var response struct {
+		Error apierror.Error `json:"error"`
+	}
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&response))
+	require.NotEmpty(t, response.Error.Code)
+	require.NotEmpty(t, response.Error.Message)
+	return response.Error
+}
+
 func TestGetUserBanner(t *testing.T) {
 	activeFeature := int(rand.Int31())
 	inactiveFeature := int(rand.Int31())
@@ -176,7 +314,20 @@ func TestGetUserBannerUseLastRevision(t *testing.T) {
 	actual = make(map[string]interface{})
 	err = json.Unmarshal(w.Body.Bytes(), &actual)
 	require.NoError(t, err)
-	require.Equal(t, "old", actual["content"])
+	require.Equal(t, "new", actual["content"])
+
+	staleBanner := schemas.Banner{ID: uint(id), FeatureID: feature, IsActive: true, TagIDs: []int64{1}, Content: schemas.JSONB{"content": "stale"}}
+	staleEntry := db.BannerCacheEntry{Banner: &staleBanner, RefreshAt: time.Now().Add(time.Minute)}
+	err = db.BannerCache.Set(context.Background(), db.BannerCacheKey(1, feature), staleEntry, db.DefaultExpiration)
+	require.NoError(t, err)
+
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, reqGet)
+	require.Equal(t, http.StatusOK, w.Code)
+	actual = make(map[string]interface{})
+	err = json.Unmarshal(w.Body.Bytes(), &actual)
+	require.NoError(t, err)
+	require.Equal(t, "stale", actual["content"])
 
 	path = fmt.Sprintf("/user_banner?tag_id=%v&feature_id=%v&use_last_revision=true", 1, feature)
 	reqGet, err = http.NewRequest(http.MethodGet, path, nil)
@@ -274,6 +425,22 @@ func TestGetBanner(t *testing.T) {
 			require.True(t, reflect.DeepEqual(test.expectedBannerIDs, actualBannerIDs))
 		}
 	}
+
+	// Negative paging is rejected by the handler even when requests aren't validated.
+	for _, query := range []string{"limit=-1", "offset=-1"} {
+		for _, r := range []*gin.Engine{router, unvalidatedRouter} {
+			w := httptest.NewRecorder()
+			req, err := http.NewRequest(http.MethodGet, "/banner?tag_id=1&"+query, nil)
+			require.NoError(t, err)
+			req.Header.Set("token", "admin_token")
+			r.ServeHTTP(w, req)
+			require.Equal(t, http.StatusBadRequest, w.Code, query)
+			require.Equal(t, apierror.CodeInvalidRequest, decodeError(t, w).Code, query)
+		}
+	}
+	banners, err := repository.NewMemoryBannerRepository().List(context.Background(), repository.BannerFilter{TagID: 1, Offset: -1})
+	require.NoError(t, err)
+	require.Empty(t, banners)
 }
 
 func TestPostBanner(t *testing.T) {
@@ -357,6 +524,20 @@ func TestPatchBanner(t *testing.T) {
 			bannerJSON:     newBannerJSON,
 			expectedStatus: http.StatusNotFound,
 		},
+		{
+			name:           "Empty tag_ids",
+			id:             existingId,
+			token:          "admin_token",
+			bannerJSON:     []byte(`{"tag_ids": []}`),
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:           "Zero feature_id",
+			id:             existingId,
+			token:          "admin_token",
+			bannerJSON:     []byte(`{"feature_id": 0}`),
+			expectedStatus: http.StatusBadRequest,
+		},
 	}
 	for _, test := range tests {
 		req, err := http.NewRequest(http.MethodPatch, fmt.Sprintf("/banner/%v", test.id), bytes.NewBuffer(test.bannerJSON))
@@ -365,8 +546,16 @@ func TestPatchBanner(t *testing.T) {
 
 		w := httptest.NewRecorder()
 		router.ServeHTTP(w, req)
-		require.Equal(t, test.expectedStatus, w.Code)
+		require.Equal(t, test.expectedStatus, w.Code, test.name)
 	}
+
+	// A null body reaches the handler when requests aren't validated and changes nothing.
+	req, err := http.NewRequest(http.MethodPatch, fmt.Sprintf("/banner/%v", existingId), bytes.NewBufferString("null"))
+	require.NoError(t, err)
+	req.Header.Set("token", "admin_token")
+	w := httptest.NewRecorder()
+	unvalidatedRouter.ServeHTTP(w, req)
+	require.Equal(t, http.StatusOK, w.Code)
 }
 
 func TestDeleteBanner(t *testing.T) {
@@ -407,3 +596,1356 @@ func TestDeleteBanner(t *testing.T) {
 		require.Equal(t, test.expectedStatus, w.Code)
 	}
 }
+
+func TestBannerVersions(t *testing.T) {
+	feature := int(rand.Int31())
+	id := addBanner(t, getBannerJSON(t, []int64{1}, feature, true, "first"))
+
+	reqPatch, err := http.NewRequest(http.MethodPatch, fmt.Sprintf("/banner/%v", id), bytes.NewBuffer(getBannerJSON(t, []int64{1}, feature, false, "second")))
+	require.NoError(t, err)
+	reqPatch.Header.Set("token", "admin_token")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, reqPatch)
+	require.Equal(t, http.StatusOK, w.Code)
+
+	reqVersions, err := http.NewRequest(http.MethodGet, fmt.Sprintf("/banner/%v/versions", id), nil)
+	require.NoError(t, err)
+	reqVersions.Header.Set("token", "admin_token")
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, reqVersions)
+	require.Equal(t, http.StatusOK, w.Code)
+
+	var versions []schemas.BannerVersion
+	err = json.NewDecoder(w.Body).Decode(&versions)
+	require.NoError(t, err)
+	require.Len(t, versions, 2)
+	require.Equal(t, 2, versions[0].Version)
+	require.Equal(t, "second", versions[0].Content["content"])
+	require.Equal(t, 1, versions[1].Version)
+	require.Equal(t, "first", versions[1].Content["content"])
+
+	var tests = []struct {
+		name           string
+		token          string
+		id             int
+		version        int
+		expectedStatus int
+	}{
+		{
+			name:           "Missing admin token",
+			token:          "user_token",
+			id:             id,
+			version:        1,
+			expectedStatus: http.StatusForbidden,
+		},
+		{
+			name:           "Version not exists",
+			token:          "admin_token",
+			id:             id,
+			version:        100,
+			expectedStatus: http.StatusNotFound,
+		},
+		{
+			name:           "OK",
+			token:          "admin_token",
+			id:             id,
+			version:        1,
+			expectedStatus: http.StatusOK,
+		},
+	}
+	for _, test := range tests {
+		req, err := http.NewRequest(http.MethodPut, fmt.Sprintf("/banner/%v/versions/%v/restore", test.id, test.version), nil)
+		require.NoError(t, err)
+		req.Header.Set("token", test.token)
+
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		require.Equal(t, test.expectedStatus, w.Code)
+	}
+
+	path := fmt.Sprintf("/user_banner?tag_id=%v&feature_id=%v&use_last_revision=true", 1, feature)
+	reqGet, err := http.NewRequest(http.MethodGet, path, nil)
+	require.NoError(t, err)
+	reqGet.Header.Set("token", "user_token")
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, reqGet)
+	require.Equal(t, http.StatusOK, w.Code)
+	var actual map[string]interface{}
+	err = json.Unmarshal(w.Body.Bytes(), &actual)
+	require.NoError(t, err)
+	require.Equal(t, "first", actual["content"])
+
+	// Restoring needs the permission for what the revision changes, as a PATCH would.
+	id = addBanner(t, getBannerJSON(t, []int64{1}, feature+1, true, "a"))
+	for _, body := range [][]byte{
+		getBannerJSON(t, []int64{1}, feature+1, true, "b"),
+		getBannerJSON(t, []int64{1}, feature+1, false, "b"),
+	} {
+		req, err := http.NewRequest(http.MethodPatch, fmt.Sprintf("/banner/%v", id), bytes.NewBuffer(body))
+		require.NoError(t, err)
+		req.Header.Set("token", "admin_token")
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		require.Equal(t, http.StatusOK, w.Code)
+	}
+	hour := time.Now().Add(time.Hour)
+	for _, test := range []struct {
+		name           string
+		role           string
+		version        int
+		expectedStatus int
+	}{
+		{name: "Editor activating", role: "editor", version: 2, expectedStatus: http.StatusForbidden},
+		{name: "Publisher activating", role: "publisher", version: 2, expectedStatus: http.StatusOK},
+		{name: "Publisher changing content", role: "publisher", version: 1, expectedStatus: http.StatusForbidden},
+		{name: "Editor changing content", role: "editor", version: 1, expectedStatus: http.StatusOK},
+	} {
+		req, err := http.NewRequest(http.MethodPut, fmt.Sprintf("/banner/%v/versions/%v/restore", id, test.version), nil)
+		require.NoError(t, err)
+		req.Header.Set("Authorization", "Bearer "+signJWT(t, jwt.SigningMethodHS256, []byte(jwtSecret), test.role, hour))
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		require.Equal(t, test.expectedStatus, w.Code, test.name)
+	}
+}
+
+func TestBannerTagConflict(t *testing.T) {
+	feature := int(rand.Int31())
+	existingId := addBanner(t, getBannerJSON(t, []int64{1, 2}, feature, true, "existing"))
+	otherId := addBanner(t, getBannerJSON(t, []int64{3}, feature, true, "other"))
+
+	var tests = []struct {
+		name              string
+		method            string
+		path              string
+		bannerJSON        []byte
+		expectedStatus    int
+		conflictingBanner int
+	}{
+		{
+			name:              "Post conflicting banner",
+			method:            http.MethodPost,
+			path:              "/banner",
+			bannerJSON:        getBannerJSON(t, []int64{2, 4}, feature, true, "conflict"),
+			expectedStatus:    http.StatusConflict,
+			conflictingBanner: existingId,
+		},
+		{
+			name:              "Patch to conflicting tags",
+			method:            http.MethodPatch,
+			path:              fmt.Sprintf("/banner/%v", otherId),
+			bannerJSON:        getBannerJSON(t, []int64{1, 3}, feature, true, "conflict"),
+			expectedStatus:    http.StatusConflict,
+			conflictingBanner: existingId,
+		},
+		{
+			name:           "Patch keeping own tags",
+			method:         http.MethodPatch,
+			path:           fmt.Sprintf("/banner/%v", otherId),
+			bannerJSON:     getBannerJSON(t, []int64{3, 5}, feature, true, "other"),
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "Post same tags with other feature",
+			method:         http.MethodPost,
+			path:           "/banner",
+			bannerJSON:     getBannerJSON(t, []int64{1, 2}, int(rand.Int31()), true, "other feature"),
+			expectedStatus: http.StatusCreated,
+		},
+	}
+	for _, test := range tests {
+		req, err := http.NewRequest(test.method, test.path, bytes.NewBuffer(test.bannerJSON))
+		require.NoError(t, err)
+		req.Header.Set("token", "admin_token")
+
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		require.Equal(t, test.expectedStatus, w.Code)
+
+		if test.expectedStatus == http.StatusConflict {
+			response := decodeError(t, w)
+			require.Equal(t, apierror.CodeBannerConflict, response.Code)
+			require.Equal(t, float64(test.conflictingBanner), response.Details["conflicting_banner_id"])
+		}
+	}
+}
+
+func getScheduledBannerJSON(t *testing.T, tagIDs []int64, featureID int, startsAt, endsAt *time.Time) []byte {
+	t.Helper()
+
+	banner := scheduledBannerRequest{
+		bannerRequest: bannerRequest{
+			TagIDs:    tagIDs,
+			FeatureID: featureID,
+			Content: map[string]interface{}{
+				"content": "scheduled",
+			},
+			IsActive: true,
+		},
+		StartsAt: startsAt,
+		EndsAt:   endsAt,
+	}
+
+	jsonData, err := json.Marshal(banner)
+	require.NoError(t, err)
+
+	return jsonData
+}
+
+type scheduledBannerRequest struct {
+	bannerRequest
+	StartsAt *time.Time `json:"starts_at,omitempty"`
+	EndsAt   *time.Time `json:"ends_at,omitempty"`
+}
+
+func TestScheduledBanners(t *testing.T) {
+	now := time.Now()
+	past := now.Add(-time.Hour)
+	future := now.Add(time.Hour)
+	tag := int64(rand.Int31())
+	currentFeature := int(rand.Int31())
+	expiredFeature := int(rand.Int31())
+	upcomingFeature := int(rand.Int31())
+
+	idCurrent := addBanner(t, getScheduledBannerJSON(t, []int64{tag}, currentFeature, &past, &future))
+	addBanner(t, getScheduledBannerJSON(t, []int64{tag}, expiredFeature, nil, &past))
+	idUpcoming := addBanner(t, getScheduledBannerJSON(t, []int64{tag}, upcomingFeature, &future, nil))
+	userToken := signJWT(t, jwt.SigningMethodHS256, []byte(jwtSecret), "", future, tag)
+
+	var tests = []struct {
+		name           string
+		featureId      int
+		expectedStatus int
+	}{
+		{
+			name:           "Inside window",
+			featureId:      currentFeature,
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "Window ended",
+			featureId:      expiredFeature,
+			expectedStatus: http.StatusForbidden,
+		},
+		{
+			name:           "Window not started",
+			featureId:      upcomingFeature,
+			expectedStatus: http.StatusForbidden,
+		},
+	}
+	for _, test := range tests {
+		path := fmt.Sprintf("/user_banner?tag_id=%v&feature_id=%v", tag, test.featureId)
+		req, err := http.NewRequest(http.MethodGet, path, nil)
+		require.NoError(t, err)
+		req.Header.Set("Authorization", "Bearer "+userToken)
+
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		require.Equal(t, test.expectedStatus, w.Code)
+	}
+
+	var listTests = []struct {
+		name              string
+		activeAt          time.Time
+		expectedBannerIDs map[int]struct{}
+	}{
+		{
+			name:              "Active now",
+			activeAt:          now,
+			expectedBannerIDs: map[int]struct{}{idCurrent: {}},
+		},
+		{
+			name:              "Active later",
+			activeAt:          future.Add(time.Minute),
+			expectedBannerIDs: map[int]struct{}{idUpcoming: {}},
+		},
+	}
+	for _, test := range listTests {
+		path := fmt.Sprintf("/banner?tag_id=%v&active_at=%v", tag, url.QueryEscape(test.activeAt.Format(time.RFC3339)))
+		req, err := http.NewRequest(http.MethodGet, path, nil)
+		require.NoError(t, err)
+		req.Header.Set("token", "admin_token")
+
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		require.Equal(t, http.StatusOK, w.Code)
+
+		var banners []schemas.Banner
+		err = json.NewDecoder(w.Body).Decode(&banners)
+		require.NoError(t, err)
+		actualBannerIDs := make(map[int]struct{})
+		for _, b := range banners {
+			actualBannerIDs[int(b.ID)] = struct{}{}
+		}
+		require.True(t, reflect.DeepEqual(test.expectedBannerIDs, actualBannerIDs))
+	}
+
+	req, err := http.NewRequest(http.MethodPost, "/banner", bytes.NewBuffer(getScheduledBannerJSON(t, []int64{tag}, int(rand.Int31()), &future, &past)))
+	require.NoError(t, err)
+	req.Header.Set("token", "admin_token")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	require.Equal(t, http.StatusBadRequest, w.Code)
+}
+
+func TestDeleteBannersByFeature(t *testing.T) {
+	feature := int(rand.Int31())
+	for i := int64(1); i <= 3; i++ {
+		addBanner(t, getBannerJSON(t, []int64{i}, feature, true, "bulk"))
+	}
+
+	req, err := http.NewRequest(http.MethodDelete, "/banner", nil)
+	require.NoError(t, err)
+	req.Header.Set("token", "admin_token")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	require.Equal(t, http.StatusBadRequest, w.Code)
+
+	req, err = http.NewRequest(http.MethodDelete, fmt.Sprintf("/banner?feature_id=%v", feature), nil)
+	require.NoError(t, err)
+	req.Header.Set("token", "admin_token")
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	require.Equal(t, http.StatusAccepted, w.Code)
+
+	var response map[string]int
+	err = json.NewDecoder(w.Body).Decode(&response)
+	require.NoError(t, err)
+	jobId := response["job_id"]
+
+	var job schemas.BannerDeletionJob
+	require.Eventually(t, func() bool {
+		req, err := http.NewRequest(http.MethodGet, fmt.Sprintf("/jobs/%v", jobId), nil)
+		require.NoError(t, err)
+		req.Header.Set("token", "admin_token")
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		require.Equal(t, http.StatusOK, w.Code)
+		require.NoError(t, json.NewDecoder(w.Body).Decode(&job))
+		return job.Status == schemas.JobDone
+	}, 5*time.Second, 50*time.Millisecond)
+	require.Equal(t, 3, job.Total)
+	require.Equal(t, 3, job.Deleted)
+
+	req, err = http.NewRequest(http.MethodGet, fmt.Sprintf("/banner?feature_id=%v", feature), nil)
+	require.NoError(t, err)
+	req.Header.Set("token", "admin_token")
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	require.Equal(t, http.StatusOK, w.Code)
+	var banners []schemas.Banner
+	err = json.NewDecoder(w.Body).Decode(&banners)
+	require.NoError(t, err)
+	require.Empty(t, banners)
+}
+
+func TestCacheInvalidationOnWrite(t *testing.T) {
+	feature := int(rand.Int31())
+	tag := int64(rand.Int31())
+	otherTag := int64(rand.Int31())
+	userToken := signJWT(t, jwt.SigningMethodHS256, []byte(jwtSecret), "", time.Now().Add(time.Hour), tag, otherTag)
+
+	getStatus := func(tagId int64) int {
+		path := fmt.Sprintf("/user_banner?tag_id=%v&feature_id=%v", tagId, feature)
+		req, err := http.NewRequest(http.MethodGet, path, nil)
+		require.NoError(t, err)
+		req.Header.Set("Authorization", "Bearer "+userToken)
+
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w.Code
+	}
+
+	require.Equal(t, http.StatusNotFound, getStatus(tag))
+	id := addBanner(t, getBannerJSON(t, []int64{tag}, feature, true, "created"))
+	require.Equal(t, http.StatusOK, getStatus(tag))
+	require.Equal(t, http.StatusNotFound, getStatus(otherTag))
+
+	reqPatch, err := http.NewRequest(http.MethodPatch, fmt.Sprintf("/banner/%v", id), bytes.NewBuffer(getBannerJSON(t, []int64{otherTag}, feature, false, "moved")))
+	require.NoError(t, err)
+	reqPatch.Header.Set("token", "admin_token")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, reqPatch)
+	require.Equal(t, http.StatusOK, w.Code)
+	require.Equal(t, http.StatusNotFound, getStatus(tag))
+	require.Equal(t, http.StatusForbidden, getStatus(otherTag))
+
+	reqDelete, err := http.NewRequest(http.MethodDelete, fmt.Sprintf("/banner/%v", id), nil)
+	require.NoError(t, err)
+	reqDelete.Header.Set("token", "admin_token")
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, reqDelete)
+	require.Equal(t, http.StatusNoContent, w.Code)
+	require.Equal(t, http.StatusNotFound, getStatus(otherTag))
+}
+
+func TestGetUserBannerStaleWhileRevalidate(t *testing.T) {
+	db.BannerCacheStaleTTL = time.Minute
+	defer func() { db.BannerCacheStaleTTL = 0 }()
+
+	feature := int(rand.Int31())
+	id := addBanner(t, getBannerJSON(t, []int64{1}, feature, true, "fresh"))
+
+	staleBanner := schemas.Banner{ID: uint(id), FeatureID: feature, IsActive: true, TagIDs: []int64{1}, Content: schemas.JSONB{"content": "stale"}}
+	staleEntry := db.BannerCacheEntry{Banner: &staleBanner, RefreshAt: time.Now().Add(-time.Second)}
+	err := db.BannerCache.Set(context.Background(), db.BannerCacheKey(1, feature), staleEntry, time.Minute)
+	require.NoError(t, err)
+
+	getContent := func() interface{} {
+		path := fmt.Sprintf("/user_banner?tag_id=%v&feature_id=%v", 1, feature)
+		req, err := http.NewRequest(http.MethodGet, path, nil)
+		require.NoError(t, err)
+		req.Header.Set("token", "user_token")
+
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		require.Equal(t, http.StatusOK, w.Code)
+		var actual map[string]interface{}
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &actual))
+		return actual["content"]
+	}
+
+	require.Equal(t, "stale", getContent())
+	require.Eventually(t, func() bool {
+		return getContent() == "fresh"
+	}, 5*time.Second, 50*time.Millisecond)
+}
+
+func TestGetUserBannerNotFoundCaching(t *testing.T) {
+	feature := int(rand.Int31())
+	path := fmt.Sprintf("/user_banner?tag_id=%v&feature_id=%v", 1, feature)
+	req, err := http.NewRequest(http.MethodGet, path, nil)
+	require.NoError(t, err)
+	req.Header.Set("token", "user_token")
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	require.Equal(t, http.StatusNotFound, w.Code)
+
+	var entry db.BannerCacheEntry
+	found, err := db.BannerCache.Get(context.Background(), db.BannerCacheKey(1, feature), &entry)
+	require.NoError(t, err)
+	require.True(t, found)
+	require.Nil(t, entry.Banner)
+	require.WithinDuration(t, time.Now().Add(db.BannerCacheNotFoundTTL), entry.RefreshAt, 5*time.Second)
+	require.Less(t, db.BannerCacheNotFoundTTL, db.BannerCacheTTL)
+}
+
+// bannerRepositoryStub replaces FindForUser of the wrapped repository.
+type bannerRepositoryStub struct {
+	repository.BannerRepository
+	findForUser func(ctx context.Context, tagId int, featureId int) (*schemas.Banner, error)
+}
+
+func (r *bannerRepositoryStub) FindForUser(ctx context.Context, tagId int, featureId int) (*schemas.Banner, error) {
+	return r.findForUser(ctx, tagId, featureId)
+}
+
+// newBannerRouter returns a router serving banners from the repository, with caches
+// of its own and the seeded users.
+func newBannerRouter(t *testing.T, banners repository.BannerRepository) (*gin.Engine, db.Cache) {
+	t.Helper()
+
+	userRepository := repository.NewMemoryUserRepository()
+	err := seed.Apply(context.Background(), seed.Default(), userRepository, repository.NewMemoryBannerRepository())
+	require.NoError(t, err)
+
+	caches := make([]*db.MemoryCache, 3)
+	for i := range caches {
+		cache := db.NewMemoryCache(db.MemoryCacheOptions{DefaultExpiration: time.Minute})
+		t.Cleanup(func() { cache.Close() })
+		caches[i] = cache
+	}
+	r := gin.New()
+	routes.SetupRoutes(r, routes.Dependencies{
+		Banners:     banners,
+		Users:       userRepository,
+		Roles:       repository.NewMemoryRoleRepository(schemas.DefaultRoles()...),
+		BannerCache: caches[0],
+		UserCache:   caches[1],
+		RoleCache:   caches[2],
+	})
+	return r, caches[0]
+}
+
+func TestGetUserBannerCoalescesLookups(t *testing.T) {
+	ctx := context.Background()
+	banners := repository.NewMemoryBannerRepository()
+	banner := schemas.Banner{FeatureID: 1, TagIDs: []int64{1}, IsActive: true, Content: schemas.JSONB{"title": "coalesced"}}
+	require.NoError(t, banners.Create(ctx, &banner))
+
+	var calls atomic.Int32
+	release := make(chan struct{})
+	r, _ := newBannerRouter(t, &bannerRepositoryStub{
+		BannerRepository: banners,
+		findForUser: func(ctx context.Context, tagId int, featureId int) (*schemas.Banner, error) {
+			calls.Add(1)
+			<-release
+			return banners.FindForUser(ctx, tagId, featureId)
+		},
+	})
+
+	const requests = 10
+	codes := make(chan int, requests)
+	for i := 0; i < requests; i++ {
+		go func() {
+			w := httptest.NewRecorder()
+			req, _ := http.NewRequest(http.MethodGet, "/user_banner?tag_id=1&feature_id=1", nil)
+			req.Header.Set("token", "user_token")
+			r.ServeHTTP(w, req)
+			codes <- w.Code
+		}()
+	}
+
+	// Requests arriving while the first lookup is blocked wait for its result.
+	require.Eventually(t, func() bool { return calls.Load() == 1 }, time.Second, time.Millisecond)
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+	for i := 0; i < requests; i++ {
+		require.Equal(t, http.StatusOK, <-codes)
+	}
+	require.EqualValues(t, 1, calls.Load())
+}
+
+func TestGetUserBannerDatabaseError(t *testing.T) {
+	ctx := context.Background()
+	banners := repository.NewMemoryBannerRepository()
+	banner := schemas.Banner{FeatureID: 1, TagIDs: []int64{1}, IsActive: true, Content: schemas.JSONB{"title": "fresh"}}
+	require.NoError(t, banners.Create(ctx, &banner))
+
+	var failing atomic.Bool
+	failing.Store(true)
+	r, cache := newBannerRouter(t, &bannerRepositoryStub{
+		BannerRepository: banners,
+		findForUser: func(ctx context.Context, tagId int, featureId int) (*schemas.Banner, error) {
+			if failing.Load() {
+				return nil, errors.New("connection refused")
+			}
+			return banners.FindForUser(ctx, tagId, featureId)
+		},
+	})
+	get := func() *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req, err := http.NewRequest(http.MethodGet, "/user_banner?tag_id=1&feature_id=1", nil)
+		require.NoError(t, err)
+		req.Header.Set("token", "user_token")
+		r.ServeHTTP(w, req)
+		return w
+	}
+
+	w := get()
+	require.Equal(t, http.StatusServiceUnavailable, w.Code)
+	require.Equal(t, apierror.CodeUnavailable, decodeError(t, w).Code)
+	var entry db.BannerCacheEntry
+	found, err := cache.Get(ctx, db.BannerCacheKey(1, 1), &entry)
+	require.NoError(t, err)
+	require.False(t, found)
+
+	// The failure isn't cached, so the next lookup reaches the database.
+	failing.Store(false)
+	w = get()
+	require.Equal(t, http.StatusOK, w.Code)
+	var content map[string]interface{}
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&content))
+	require.Equal(t, "fresh", content["title"])
+}
+
+func signJWT(t *testing.T, method jwt.SigningMethod, key interface{}, role string, expiresAt time.Time, tags ...int64) string {
+	t.Helper()
+
+	claims := middlewares.Claims{
+		RegisteredClaims: jwt.RegisteredClaims{Subject: "42"},
+		Role:             role,
+		Tags:             tags,
+	}
+	if !expiresAt.IsZero() {
+		claims.ExpiresAt = jwt.NewNumericDate(expiresAt)
+	}
+	token, err := jwt.NewWithClaims(method, claims).SignedString(key)
+	require.NoError(t, err)
+
+	return token
+}
+
+func TestJWTAuthorization(t *testing.T) {
+	feature := int(rand.Int31())
+	addBanner(t, getBannerJSON(t, []int64{1}, feature, true, "jwt"))
+	rsaKey, err := rsa.GenerateKey(crand.Reader, 2048)
+	require.NoError(t, err)
+
+	hour := time.Now().Add(time.Hour)
+	var tests = []struct {
+		name           string
+		path           string
+		token          string
+		expectedStatus int
+		expectedCode   apierror.Code
+	}{
+		{
+			name:           "OK user",
+			path:           fmt.Sprintf("/user_banner?tag_id=1&feature_id=%v", feature),
+			token:          signJWT(t, jwt.SigningMethodHS256, []byte(jwtSecret), "user", hour, 1),
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "OK admin",
+			path:           fmt.Sprintf("/banner?feature_id=%v", feature),
+			token:          signJWT(t, jwt.SigningMethodHS256, []byte(jwtSecret), "admin", hour),
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "Missing admin role",
+			path:           fmt.Sprintf("/banner?feature_id=%v", feature),
+			token:          signJWT(t, jwt.SigningMethodHS256, []byte(jwtSecret), "user", hour),
+			expectedStatus: http.StatusForbidden,
+			expectedCode:   apierror.CodeForbidden,
+		},
+		{
+			name:           "Not a tag member",
+			path:           fmt.Sprintf("/user_banner?tag_id=1&feature_id=%v", feature),
+			token:          signJWT(t, jwt.SigningMethodHS256, []byte(jwtSecret), "user", hour, 2),
+			expectedStatus: http.StatusForbidden,
+			expectedCode:   apierror.CodeNotTagMember,
+		},
+		{
+			name:           "Expired",
+			path:           fmt.Sprintf("/user_banner?tag_id=1&feature_id=%v", feature),
+			token:          signJWT(t, jwt.SigningMethodHS256, []byte(jwtSecret), "user", time.Now().Add(-time.Hour)),
+			expectedStatus: http.StatusUnauthorized,
+			expectedCode:   apierror.CodeTokenExpired,
+		},
+		{
+			name:           "Invalid signature",
+			path:           fmt.Sprintf("/user_banner?tag_id=1&feature_id=%v", feature),
+			token:          signJWT(t, jwt.SigningMethodHS256, []byte("other_secret"), "user", hour),
+			expectedStatus: http.StatusUnauthorized,
+			expectedCode:   apierror.CodeInvalidToken,
+		},
+		{
+			name:           "No expiration",
+			path:           fmt.Sprintf("/banner?feature_id=%v", feature),
+			token:          signJWT(t, jwt.SigningMethodHS256, []byte(jwtSecret), "admin", time.Time{}),
+			expectedStatus: http.StatusUnauthorized,
+			expectedCode:   apierror.CodeInvalidToken,
+		},
+		{
+			name:           "Unknown key",
+			path:           fmt.Sprintf("/user_banner?tag_id=1&feature_id=%v", feature),
+			token:          signJWT(t, jwt.SigningMethodRS256, rsaKey, "user", hour),
+			expectedStatus: http.StatusUnauthorized,
+			expectedCode:   apierror.CodeInvalidToken,
+		},
+	}
+	for _, test := range tests {
+		req, err := http.NewRequest(http.MethodGet, test.path, nil)
+		require.NoError(t, err)
+		req.Header.Set("Authorization", "Bearer "+test.token)
+
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		require.Equal(t, test.expectedStatus, w.Code, test.name)
+
+		if len(test.expectedCode) > 0 {
+			require.Equal(t, test.expectedCode, decodeError(t, w).Code, test.name)
+		}
+	}
+}
+
+func TestRolePermissions(t *testing.T) {
+	feature := int(rand.Int31())
+	id := addBanner(t, getBannerJSON(t, []int64{1}, feature, true, "rbac"))
+	hour := time.Now().Add(time.Hour)
+
+	var tests = []struct {
+		name           string
+		role           string
+		method         string
+		path           string
+		body           []byte
+		expectedStatus int
+	}{
+		{
+			name:           "Viewer reads banners",
+			role:           "viewer",
+			method:         http.MethodGet,
+			path:           fmt.Sprintf("/banner?feature_id=%v", feature),
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "Viewer can't create banners",
+			role:           "viewer",
+			method:         http.MethodPost,
+			path:           "/banner",
+			body:           getBannerJSON(t, []int64{2}, feature, true, "viewer"),
+			expectedStatus: http.StatusForbidden,
+		},
+		{
+			name:           "Editor can't toggle activity",
+			role:           "editor",
+			method:         http.MethodPatch,
+			path:           fmt.Sprintf("/banner/%v", id),
+			body:           getBannerJSON(t, []int64{1}, feature, false, "rbac"),
+			expectedStatus: http.StatusForbidden,
+		},
+		{
+			name:           "Editor edits content",
+			role:           "editor",
+			method:         http.MethodPatch,
+			path:           fmt.Sprintf("/banner/%v", id),
+			body:           getBannerJSON(t, []int64{1}, feature, true, "edited"),
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "Publisher can't edit content",
+			role:           "publisher",
+			method:         http.MethodPatch,
+			path:           fmt.Sprintf("/banner/%v", id),
+			body:           getBannerJSON(t, []int64{1}, feature, true, "published"),
+			expectedStatus: http.StatusForbidden,
+		},
+		{
+			name:           "Publisher toggles activity",
+			role:           "publisher",
+			method:         http.MethodPatch,
+			path:           fmt.Sprintf("/banner/%v", id),
+			body:           getBannerJSON(t, []int64{1}, feature, false, "edited"),
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "Editor can't delete banners",
+			role:           "editor",
+			method:         http.MethodDelete,
+			path:           fmt.Sprintf("/banner/%v", id),
+			expectedStatus: http.StatusForbidden,
+		},
+		{
+			name:           "Editor can't manage roles",
+			role:           "editor",
+			method:         http.MethodGet,
+			path:           "/roles",
+			expectedStatus: http.StatusForbidden,
+		},
+	}
+	for _, test := range tests {
+		req, err := http.NewRequest(test.method, test.path, bytes.NewBuffer(test.body))
+		require.NoError(t, err)
+		req.Header.Set("Authorization", "Bearer "+signJWT(t, jwt.SigningMethodHS256, []byte(jwtSecret), test.role, hour))
+
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		require.Equal(t, test.expectedStatus, w.Code, test.name)
+	}
+}
+
+func TestAuthorizationCacheErrors(t *testing.T) {
+	ctx := context.Background()
+	// Entries of another shape can't be read, as after a cached struct changes.
+	require.NoError(t, db.UserCache.Set(ctx, schemas.HashToken("admin_token"), "not a user", db.DefaultExpiration))
+	require.NoError(t, db.RoleCache.Set(ctx, "admin", "not a role", db.DefaultExpiration))
+
+	w := httptest.NewRecorder()
+	req, err := http.NewRequest(http.MethodGet, "/banner?feature_id=1", nil)
+	require.NoError(t, err)
+	req.Header.Set("token", "admin_token")
+	router.ServeHTTP(w, req)
+	require.Equal(t, http.StatusOK, w.Code)
+
+	// The database results replace the unreadable entries.
+	var user schemas.User
+	found, err := db.UserCache.Get(ctx, schemas.HashToken("admin_token"), &user)
+	require.NoError(t, err)
+	require.True(t, found)
+	var role schemas.Role
+	found, err = db.RoleCache.Get(ctx, "admin", &role)
+	require.NoError(t, err)
+	require.True(t, found)
+	require.True(t, role.Has(schemas.PermissionReadBanners))
+}
+
+func TestManageRoles(t *testing.T) {
+	role := fmt.Sprintf("role%d", rand.Int31())
+	token := signJWT(t, jwt.SigningMethodHS256, []byte(jwtSecret), role, time.Now().Add(time.Hour))
+
+	doRequest := func(method string, path string, body string, authorization string) *httptest.ResponseRecorder {
+		req, err := http.NewRequest(method, path, bytes.NewBufferString(body))
+		require.NoError(t, err)
+		if len(authorization) > 0 {
+			req.Header.Set("Authorization", "Bearer "+authorization)
+		} else {
+			req.Header.Set("token", "admin_token")
+		}
+
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	require.Equal(t, http.StatusForbidden, doRequest(http.MethodGet, "/banner?feature_id=1", "", token).Code)
+
+	w := doRequest(http.MethodPut, "/roles/"+role, `{"permissions": ["banners:read", "unknown"]}`, "")
+	require.Equal(t, http.StatusBadRequest, w.Code)
+	w = doRequest(http.MethodPut, "/roles/"+role, `{"permissions": ["banners:read"]}`, "")
+	require.Equal(t, http.StatusOK, w.Code)
+	require.Equal(t, http.StatusOK, doRequest(http.MethodGet, "/banner?feature_id=1", "", token).Code)
+
+	w = doRequest(http.MethodGet, "/roles", "", "")
+	require.Equal(t, http.StatusOK, w.Code)
+	var roles []schemas.Role
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&roles))
+	names := make(map[string]struct{})
+	for _, r := range roles {
+		names[r.Name] = struct{}{}
+	}
+	require.Contains(t, names, role)
+	require.Contains(t, names, "admin")
+
+	require.Equal(t, http.StatusNoContent, doRequest(http.MethodDelete, "/roles/"+role, "", "").Code)
+	require.Equal(t, http.StatusNotFound, doRequest(http.MethodDelete, "/roles/"+role, "", "").Code)
+	require.Equal(t, http.StatusForbidden, doRequest(http.MethodGet, "/banner?feature_id=1", "", token).Code)
+}
+
+func TestUserTagMembership(t *testing.T) {
+	tag := int64(rand.Int31())
+	feature := int(rand.Int31())
+	addBanner(t, getBannerJSON(t, []int64{tag}, feature, true, "members only"))
+	user, err := users.FindByTokenHash(context.Background(), schemas.HashToken("user_token"))
+	require.NoError(t, err)
+
+	doRequest := func(method string, path string, token string) *httptest.ResponseRecorder {
+		req, err := http.NewRequest(method, path, nil)
+		require.NoError(t, err)
+		req.Header.Set("token", token)
+
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+	bannerPath := fmt.Sprintf("/user_banner?tag_id=%v&feature_id=%v", tag, feature)
+	tagPath := fmt.Sprintf("/users/%v/tags/%v", user.ID, tag)
+
+	require.Equal(t, http.StatusForbidden, doRequest(http.MethodGet, bannerPath, "user_token").Code)
+	require.Equal(t, http.StatusOK, doRequest(http.MethodGet, bannerPath, "admin_token").Code)
+	require.Equal(t, http.StatusForbidden, doRequest(http.MethodPut, tagPath, "user_token").Code)
+
+	require.Equal(t, http.StatusNoContent, doRequest(http.MethodPut, tagPath, "admin_token").Code)
+	require.Equal(t, http.StatusOK, doRequest(http.MethodGet, bannerPath, "user_token").Code)
+
+	w := doRequest(http.MethodGet, fmt.Sprintf("/users/%v/tags", user.ID), "admin_token")
+	require.Equal(t, http.StatusOK, w.Code)
+	var response struct {
+		TagIDs []int64 `json:"tag_ids"`
+	}
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&response))
+	require.Contains(t, response.TagIDs, tag)
+
+	require.Equal(t, http.StatusNoContent, doRequest(http.MethodDelete, tagPath, "admin_token").Code)
+	require.Equal(t, http.StatusNotFound, doRequest(http.MethodDelete, tagPath, "admin_token").Code)
+	require.Equal(t, http.StatusForbidden, doRequest(http.MethodGet, bannerPath, "user_token").Code)
+	require.Equal(t, http.StatusNotFound, doRequest(http.MethodGet, "/users/999999999/tags", "admin_token").Code)
+}
+
+func TestManageUsers(t *testing.T) {
+	tag := int64(rand.Int31())
+	feature := int(rand.Int31())
+	addBanner(t, getBannerJSON(t, []int64{tag}, feature, true, "managed"))
+	bannerPath := fmt.Sprintf("/user_banner?tag_id=%v&feature_id=%v", tag, feature)
+	listPath := fmt.Sprintf("/banner?feature_id=%v", feature)
+
+	doRequest := func(method string, path string, body string, token string) *httptest.ResponseRecorder {
+		req, err := http.NewRequest(method, path, bytes.NewBufferString(body))
+		require.NoError(t, err)
+		req.Header.Set("token", token)
+
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	w := doRequest(http.MethodPost, "/users", `{"role": "unknown"}`, "admin_token")
+	require.Equal(t, http.StatusBadRequest, w.Code)
+	w = doRequest(http.MethodPost, "/users", fmt.Sprintf(`{"tag_ids": [%v]}`, tag), "user_token")
+	require.Equal(t, http.StatusForbidden, w.Code)
+	w = doRequest(http.MethodPost, "/users", fmt.Sprintf(`{"tag_ids": [%v]}`, tag), "admin_token")
+	require.Equal(t, http.StatusCreated, w.Code)
+	var created struct {
+		UserID uint   `json:"user_id"`
+		Token  string `json:"token"`
+	}
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&created))
+	require.NotEmpty(t, created.Token)
+	token := created.Token
+
+	require.Equal(t, http.StatusOK, doRequest(http.MethodGet, bannerPath, "", token).Code)
+	require.Equal(t, http.StatusForbidden, doRequest(http.MethodGet, listPath, "", token).Code)
+
+	w = doRequest(http.MethodPut, fmt.Sprintf("/users/%v/role", created.UserID), `{"role": "viewer"}`, "admin_token")
+	require.Equal(t, http.StatusOK, w.Code)
+	require.Equal(t, http.StatusOK, doRequest(http.MethodGet, listPath, "", token).Code)
+
+	for _, query := range []string{"limit=-1", "offset=-1"} {
+		w = doRequest(http.MethodGet, "/users?"+query, "", "admin_token")
+		require.Equal(t, http.StatusBadRequest, w.Code, query)
+	}
+	clamped, err := users.List(context.Background(), -1, -1)
+	require.NoError(t, err)
+	require.NotEmpty(t, clamped)
+
+	w = doRequest(http.MethodGet, "/users?limit=1000000", "", "admin_token")
+	require.Equal(t, http.StatusOK, w.Code)
+	var listed []map[string]interface{}
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&listed))
+	var found map[string]interface{}
+	for _, user := range listed {
+		if user["ID"] == float64(created.UserID) {
+			found = user
+		}
+	}
+	require.NotNil(t, found)
+	require.Equal(t, "viewer", found["role"])
+	require.NotContains(t, found, "token")
+	require.NotContains(t, found, "token_hash")
+
+	w = doRequest(http.MethodPost, fmt.Sprintf("/users/%v/token", created.UserID), "", "admin_token")
+	require.Equal(t, http.StatusCreated, w.Code)
+	var rotated map[string]string
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&rotated))
+	require.NotEqual(t, token, rotated["token"])
+	require.Equal(t, http.StatusUnauthorized, doRequest(http.MethodGet, bannerPath, "", token).Code)
+	token = rotated["token"]
+	require.Equal(t, http.StatusOK, doRequest(http.MethodGet, bannerPath, "", token).Code)
+
+	require.Equal(t, http.StatusNoContent, doRequest(http.MethodDelete, fmt.Sprintf("/users/%v/token", created.UserID), "", "admin_token").Code)
+	require.Equal(t, http.StatusUnauthorized, doRequest(http.MethodGet, bannerPath, "", token).Code)
+	require.Equal(t, http.StatusNotFound, doRequest(http.MethodDelete, fmt.Sprintf("/users/%v/token", created.UserID), "", "admin_token").Code)
+}
+
+func TestHealthEndpoints(t *testing.T) {
+	get := func(t *testing.T, r *gin.Engine, path string) (int, map[string]interface{}) {
+		t.Helper()
+		w := httptest.NewRecorder()
+		req, err := http.NewRequest("GET", path, nil)
+		require.NoError(t, err)
+		r.ServeHTTP(w, req)
+
+		var response map[string]interface{}
+		require.NoError(t, json.NewDecoder(w.Body).Decode(&response))
+		return w.Code, response
+	}
+
+	t.Run("Without token", func(t *testing.T) {
+		code, response := get(t, router, "/healthz")
+		require.Equal(t, http.StatusOK, code)
+		require.Equal(t, health.StatusOK, response["status"])
+
+		code, response = get(t, router, "/readyz")
+		require.Equal(t, http.StatusOK, code)
+		require.Equal(t, health.StatusOK, response["status"])
+	})
+
+	checker := health.NewChecker()
+	checker.Add("roles", func(ctx context.Context) error {
+		return middlewares.WarmRoleCache(ctx, repository.NewMemoryRoleRepository(schemas.DefaultRoles()...), db.NewMemoryCache(db.MemoryCacheOptions{DefaultExpiration: time.Minute}))
+	})
+	failing := true
+	checker.Add("database", func(ctx context.Context) error {
+		if failing {
+			return errors.New("connection refused")
+		}
+		return nil
+	})
+	r := gin.New()
+	routes.SetupRoutes(r, routes.Dependencies{Health: checker})
+
+	t.Run("Failing check", func(t *testing.T) {
+		code, response := get(t, r, "/readyz")
+		require.Equal(t, http.StatusServiceUnavailable, code)
+		require.Equal(t, health.StatusUnavailable, response["status"])
+
+		checks := response["checks"].(map[string]interface{})
+		require.Equal(t, health.StatusOK, checks["roles"].(map[string]interface{})["status"])
+		database := checks["database"].(map[string]interface{})
+		require.Equal(t, health.StatusUnavailable, database["status"])
+		require.Equal(t, "connection refused", database["error"])
+
+		code, _ = get(t, r, "/healthz")
+		require.Equal(t, http.StatusOK, code)
+	})
+
+	t.Run("Shutting down", func(t *testing.T) {
+		failing = false
+		code, _ := get(t, r, "/readyz")
+		require.Equal(t, http.StatusOK, code)
+
+		checker.SetShuttingDown()
+		code, response := get(t, r, "/readyz")
+		require.Equal(t, http.StatusServiceUnavailable, code)
+		require.Equal(t, health.StatusShuttingDown, response["status"])
+
+		code, _ = get(t, r, "/healthz")
+		require.Equal(t, http.StatusOK, code)
+	})
+}
+
+func TestMetrics(t *testing.T) {
+	feature := int(rand.Int31())
+	addBanner(t, getBannerJSON(t, []int64{1}, feature, true, "metrics"))
+
+	for i := 0; i < 2; i++ {
+		w := httptest.NewRecorder()
+		req, err := http.NewRequest("GET", fmt.Sprintf("/user_banner?tag_id=1&feature_id=%d", feature), nil)
+		require.NoError(t, err)
+		req.Header.Set("token", "user_token")
+		router.ServeHTTP(w, req)
+		require.Equal(t, http.StatusOK, w.Code)
+	}
+
+	w := httptest.NewRecorder()
+	req, err := http.NewRequest("GET", "/metrics", nil)
+	require.NoError(t, err)
+	router.ServeHTTP(w, req)
+	require.Equal(t, http.StatusOK, w.Code)
+
+	body := w.Body.String()
+	require.Contains(t, body, `http_requests_total{method="GET",route="/user_banner",status="200"}`)
+	require.Contains(t, body, `http_requests_total{method="POST",route="/banner",status="201"}`)
+	require.Contains(t, body, `http_request_duration_seconds_bucket{method="GET",route="/user_banner",status="200",le="+Inf"}`)
+	for _, cache := range []string{"banner", "user"} {
+		for _, metric := range []string{"cache_hits_total", "cache_misses_total", "cache_evictions_total"} {
+			require.Contains(t, body, fmt.Sprintf(`%s{cache="%s"}`, metric, cache))
+		}
+	}
+	if db.DB != nil {
+		require.Contains(t, body, `db_query_duration_seconds_count{operation="query",table="banners",status="ok"}`)
+		require.Contains(t, body, "go_sql_open_connections")
+	}
+
+	t.Run("Panic", func(t *testing.T) {
+		r := gin.New()
+		r.Use(apierror.Recovery(), metrics.Middleware())
+		r.GET("/metrics_panic", func(c *gin.Context) {
+			panic("handler failed")
+		})
+		r.GET("/metrics", gin.WrapH(metrics.Handler()))
+
+		w := httptest.NewRecorder()
+		req, err := http.NewRequest("GET", "/metrics_panic", nil)
+		require.NoError(t, err)
+		r.ServeHTTP(w, req)
+		require.Equal(t, http.StatusInternalServerError, w.Code)
+
+		w = httptest.NewRecorder()
+		req, err = http.NewRequest("GET", "/metrics", nil)
+		require.NoError(t, err)
+		r.ServeHTTP(w, req)
+		require.Contains(t, w.Body.String(), `http_requests_total{method="GET",route="/metrics_panic",status="500"} 1`)
+	})
+}
+
+func TestTracing(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	previous := otel.GetTracerProvider()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+	t.Cleanup(func() { otel.SetTracerProvider(previous) })
+
+	feature := int(rand.Int31())
+	addBanner(t, getBannerJSON(t, []int64{1}, feature, true, "traced"))
+
+	const traceId = "4bf92f3577b34da6a3ce929d0e0e4736"
+	getUserBanner := func(t *testing.T) {
+		w := httptest.NewRecorder()
+		req, err := http.NewRequest("GET", fmt.Sprintf("/user_banner?tag_id=1&feature_id=%d", feature), nil)
+		require.NoError(t, err)
+		req.Header.Set("token", "user_token")
+		req.Header.Set("traceparent", "00-"+traceId+"-00f067aa0ba902b7-01")
+		router.ServeHTTP(w, req)
+		require.Equal(t, http.StatusOK, w.Code)
+	}
+	// spans returns the request spans ended since the previous call by name.
+	var seen int
+	spans := func(t *testing.T) map[string]sdktrace.ReadOnlySpan {
+		ended := recorder.Ended()
+		byName := make(map[string]sdktrace.ReadOnlySpan)
+		for _, span := range ended[seen:] {
+			if span.SpanContext().TraceID().String() == traceId {
+				byName[span.Name()] = span
+			}
+		}
+		seen = len(ended)
+		return byName
+	}
+	attribute := func(span sdktrace.ReadOnlySpan, key string) interface{} {
+		for _, kv := range span.Attributes() {
+			if string(kv.Key) == key {
+				return kv.Value.AsInterface()
+			}
+		}
+		return nil
+	}
+
+	getUserBanner(t)
+	first := spans(t)
+	require.Contains(t, first, "GET /user_banner")
+	require.Contains(t, first, "IsAuthorized")
+	require.Contains(t, first, "lookupUserBanner")
+
+	request := first["GET /user_banner"]
+	require.Equal(t, "00f067aa0ba902b7", request.Parent().SpanID().String())
+	require.EqualValues(t, http.StatusOK, attribute(request, "http.response.status_code"))
+	require.Equal(t, request.SpanContext().SpanID(), first["IsAuthorized"].Parent().SpanID())
+	require.Equal(t, request.SpanContext().SpanID(), first["lookupUserBanner"].Parent().SpanID())
+	require.Equal(t, false, attribute(first["lookupUserBanner"], "banner.cache_hit"))
+	if db.DB != nil {
+		require.Contains(t, first, "gorm.query")
+	}
+
+	getUserBanner(t)
+	second := spans(t)
+	require.Equal(t, true, attribute(second["lookupUserBanner"], "banner.cache_hit"))
+	require.Equal(t, true, attribute(second["IsAuthorized"], "auth.user_cache_hit"))
+}
+
+func TestRequestLogging(t *testing.T) {
+	user, err := users.FindByTokenHash(context.Background(), schemas.HashToken("user_token"))
+	require.NoError(t, err)
+
+	get := func(t *testing.T, path string, requestId string) *httptest.ResponseRecorder {
+		t.Helper()
+		w := httptest.NewRecorder()
+		req, err := http.NewRequest("GET", path, nil)
+		require.NoError(t, err)
+		req.Header.Set("token", "user_token")
+		if len(requestId) > 0 {
+			req.Header.Set(middlewares.RequestIDHeader, requestId)
+		}
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	t.Run("Request ID from header", func(t *testing.T) {
+		requestId := fmt.Sprintf("test-%d", rand.Int63())
+		w := get(t, "/user_banner?tag_id=1&feature_id=1", requestId)
+		require.Equal(t, requestId, w.Header().Get(middlewares.RequestIDHeader))
+
+		records := requestLog.records(t, requestId)
+		require.Len(t, records, 1)
+		require.Equal(t, "INFO", records[0]["level"])
+		require.Equal(t, "GET", records[0]["method"])
+		require.Equal(t, "/user_banner", records[0]["route"])
+		require.EqualValues(t, w.Code, records[0]["status"])
+		require.EqualValues(t, user.ID, records[0]["user_id"])
+		require.Contains(t, records[0], "latency_ms")
+		require.NotContains(t, records[0], "error")
+	})
+
+	t.Run("Generated request ID", func(t *testing.T) {
+		for _, requestId := range []string{"", "bad\tid", strings.Repeat("a", 129)} {
+			w := get(t, "/user_banner?tag_id=1&feature_id=1", requestId)
+			generated := w.Header().Get(middlewares.RequestIDHeader)
+			require.Regexp(t, "^[0-9a-f]{32}$", generated)
+			require.Len(t, requestLog.records(t, generated), 1)
+		}
+	})
+
+	t.Run("Handler error", func(t *testing.T) {
+		requestId := fmt.Sprintf("test-%d", rand.Int63())
+		w := get(t, "/user_banner?tag_id=one&feature_id=1", requestId)
+		require.Equal(t, http.StatusBadRequest, w.Code)
+
+		records := requestLog.records(t, requestId)
+		require.Len(t, records, 1)
+		require.Equal(t, "WARN", records[0]["level"])
+		require.Contains(t, records[0]["error"], "tag_id")
+	})
+
+	t.Run("Authorization error", func(t *testing.T) {
+		requestId := fmt.Sprintf("test-%d", rand.Int63())
+		w := httptest.NewRecorder()
+		req, err := http.NewRequest("GET", "/user_banner?tag_id=1&feature_id=1", nil)
+		require.NoError(t, err)
+		req.Header.Set("Authorization", "Bearer not-a-jwt")
+		req.Header.Set(middlewares.RequestIDHeader, requestId)
+		router.ServeHTTP(w, req)
+		require.Equal(t, http.StatusUnauthorized, w.Code)
+
+		records := requestLog.records(t, requestId)
+		require.Len(t, records, 1)
+		require.Contains(t, records[0]["error"], "invalid token")
+		require.NotContains(t, records[0], "user_id")
+	})
+}
+
+func TestOpenAPIContract(t *testing.T) {
+	t.Run("Document served", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		req, err := http.NewRequest("GET", "/openapi.json", nil)
+		require.NoError(t, err)
+		router.ServeHTTP(w, req)
+		require.Equal(t, http.StatusOK, w.Code)
+
+		doc, err := openapi3.NewLoader().LoadFromData(w.Body.Bytes())
+		require.NoError(t, err)
+		require.NoError(t, doc.Validate(context.Background()))
+	})
+
+	t.Run("Every route documented", func(t *testing.T) {
+		doc := openapi.MustLoad()
+		param := regexp.MustCompile(`:(\w+)`)
+		for _, route := range router.Routes() {
+			path := doc.Paths.Find(param.ReplaceAllString(route.Path, "{$1}"))
+			require.NotNil(t, path, "%s %s is not in the OpenAPI document", route.Method, route.Path)
+			require.NotNil(t, path.GetOperation(route.Method), "%s %s is not in the OpenAPI document", route.Method, route.Path)
+		}
+	})
+
+	t.Run("Drift detected", func(t *testing.T) {
+		validator, err := openapi.Validator(openapi.MustLoad(), openapi.ValidatorOptions{Responses: true})
+		require.NoError(t, err)
+		r := gin.New()
+		r.Use(validator)
+		r.GET("/healthz", func(c *gin.Context) {
+			c.JSON(http.StatusOK, gin.H{"status": 1})
+		})
+		r.GET("/readyz", func(c *gin.Context) {
+			c.Status(http.StatusTeapot)
+		})
+		r.GET("/undocumented", func(c *gin.Context) {
+			c.Status(http.StatusOK)
+		})
+
+		for _, path := range []string{"/healthz", "/readyz", "/undocumented"} {
+			w := httptest.NewRecorder()
+			req, err := http.NewRequest("GET", path, nil)
+			require.NoError(t, err)
+			r.ServeHTTP(w, req)
+			require.Equal(t, http.StatusInternalServerError, w.Code, path)
+
+			require.Equal(t, apierror.CodeContractViolation, decodeError(t, w).Code, path)
+		}
+	})
+}
+
+func TestErrorEnvelope(t *testing.T) {
+	feature := int(rand.Int31())
+	var tests = []struct {
+		name           string
+		method         string
+		path           string
+		token          string
+		expectedStatus int
+		expectedCode   apierror.Code
+		expectedDetail string
+	}{
+		{
+			name:           "No token",
+			method:         http.MethodGet,
+			path:           "/banner",
+			expectedStatus: http.StatusUnauthorized,
+			expectedCode:   apierror.CodeUnauthorized,
+		},
+		{
+			name:           "Unknown token",
+			method:         http.MethodGet,
+			path:           "/banner",
+			token:          "unknown_token",
+			expectedStatus: http.StatusUnauthorized,
+			expectedCode:   apierror.CodeUnauthorized,
+		},
+		{
+			name:           "Missing permission",
+			method:         http.MethodGet,
+			path:           "/banner",
+			token:          "user_token",
+			expectedStatus: http.StatusForbidden,
+			expectedCode:   apierror.CodeForbidden,
+			expectedDetail: "required_permissions",
+		},
+		{
+			name:           "Invalid parameter",
+			method:         http.MethodGet,
+			path:           "/user_banner?tag_id=one&feature_id=1",
+			token:          "user_token",
+			expectedStatus: http.StatusBadRequest,
+			expectedCode:   apierror.CodeInvalidRequest,
+			expectedDetail: "parameter",
+		},
+		{
+			name:           "Invalid id",
+			method:         http.MethodDelete,
+			path:           "/banner/0",
+			token:          "admin_token",
+			expectedStatus: http.StatusBadRequest,
+			expectedCode:   apierror.CodeInvalidRequest,
+		},
+		{
+			name:           "No banner",
+			method:         http.MethodGet,
+			path:           fmt.Sprintf("/user_banner?tag_id=1&feature_id=%v", feature),
+			token:          "user_token",
+			expectedStatus: http.StatusNotFound,
+			expectedCode:   apierror.CodeNotFound,
+			expectedDetail: "feature_id",
+		},
+		{
+			name:           "No job",
+			method:         http.MethodGet,
+			path:           "/jobs/999999999",
+			token:          "admin_token",
+			expectedStatus: http.StatusNotFound,
+			expectedCode:   apierror.CodeNotFound,
+			expectedDetail: "job_id",
+		},
+		{
+			name:           "No route",
+			method:         http.MethodGet,
+			path:           "/nowhere",
+			expectedStatus: http.StatusNotFound,
+			expectedCode:   apierror.CodeNotFound,
+		},
+	}
+	for _, test := range tests {
+		req, err := http.NewRequest(test.method, test.path, nil)
+		require.NoError(t, err)
+		if len(test.token) > 0 {
+			req.Header.Set("token", test.token)
+		}
+
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		require.Equal(t, test.expectedStatus, w.Code, test.name)
+
+		response := decodeError(t, w)
+		require.Equal(t, test.expectedCode, response.Code, test.name)
+		if len(test.expectedDetail) > 0 {
+			require.Contains(t, response.Details, test.expectedDetail, test.name)
+		}
+	}
+
+	t.Run("Internal causes are only logged", func(t *testing.T) {
+		var logged []string
+		r := gin.New()
+		r.Use(func(c *gin.Context) {
+			c.Next()
+			logged = append(logged, c.Errors.String())
+		}, apierror.Recovery())
+		r.GET("/storage", func(c *gin.Context) {
+			apierror.Respond(c, apierror.Internal("error getting banner: %w", errors.New("pq: relation \"banners\" does not exist")))
+		})
+		r.GET("/unavailable", func(c *gin.Context) {
+			apierror.Respond(c, apierror.New(http.StatusServiceUnavailable, apierror.CodeUnavailable, "error getting banner: %w", errors.New("dial tcp 10.0.0.1:5432")))
+		})
+		r.GET("/panic", func(c *gin.Context) {
+			panic("secret value")
+		})
+
+		for path, expected := range map[string]string{"/storage": "relation", "/unavailable": "10.0.0.1", "/panic": "secret value"} {
+			w := httptest.NewRecorder()
+			req, err := http.NewRequest("GET", path, nil)
+			require.NoError(t, err)
+			r.ServeHTTP(w, req)
+			require.NotContains(t, w.Body.String(), expected, path)
+			response := decodeError(t, w)
+			require.Contains(t, []string{"internal error", "service temporarily unavailable"}, response.Message, path)
+			require.Contains(t, logged[len(logged)-1], expected, path)
+		}
+	})
+}
@@ -0,0 +1,150 @@
+package unit_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/require"
+
+	"server/config"
+	"server/db"
+	"server/schemas"
+)
+
+func newCaches(t *testing.T) map[string]db.Cache {
+	t.Helper()
+
+	server := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: server.Addr()})
+	t.Cleanup(func() { client.Close() })
+
+	return map[string]db.Cache{
+		"memory": db.NewMemoryCache(db.MemoryCacheOptions{DefaultExpiration: time.Minute}),
+		"redis":  db.NewRedisCache(client, "test:", time.Minute),
+	}
+}
+
+func TestCacheSetGetDelete(t *testing.T) {
+	ctx := context.Background()
+	for name, c := range newCaches(t) {
+		t.Run(name, func(t *testing.T) {
+			banner := schemas.Banner{ID: 1, FeatureID: 2, IsActive: true, TagIDs: []int64{3, 4}, Content: schemas.JSONB{"title": "banner"}}
+			require.NoError(t, c.Set(ctx, "3,2", banner, db.DefaultExpiration))
+
+			var actual schemas.Banner
+			found, err := c.Get(ctx, "3,2", &actual)
+			require.NoError(t, err)
+			require.True(t, found)
+			require.Equal(t, banner.ID, actual.ID)
+			require.Equal(t, banner.TagIDs, actual.TagIDs)
+			require.Equal(t, "banner", actual.Content["title"])
+
+			require.NoError(t, c.Delete(ctx, "3,2"))
+			found, err = c.Get(ctx, "3,2", &actual)
+			require.NoError(t, err)
+			require.False(t, found)
+		})
+	}
+}
+
+func TestCacheInvalidEntry(t *testing.T) {
+	ctx := context.Background()
+	for name, c := range newCaches(t) {
+		t.Run(name, func(t *testing.T) {
+			require.NoError(t, c.Set(ctx, "key", "not a user", db.DefaultExpiration))
+
+			var user schemas.User
+			_, err := c.Get(ctx, "key", &user)
+			require.ErrorIs(t, err, db.ErrInvalidCacheEntry)
+		})
+	}
+}
+
+func TestRedisCacheExpiration(t *testing.T) {
+	ctx := context.Background()
+	server := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: server.Addr()})
+	defer client.Close()
+
+	c := db.NewRedisCache(client, "test:", time.Minute)
+	require.NoError(t, c.Set(ctx, "default", 1, db.DefaultExpiration))
+	require.NoError(t, c.Set(ctx, "short", 1, time.Second))
+	require.Equal(t, time.Minute, server.TTL("test:default"))
+
+	server.FastForward(2 * time.Second)
+	var value int
+	found, err := c.Get(ctx, "short", &value)
+	require.NoError(t, err)
+	require.False(t, found)
+	found, err = c.Get(ctx, "default", &value)
+	require.NoError(t, err)
+	require.True(t, found)
+	require.Equal(t, 1, value)
+}
+
+func TestCacheInvalidationChannel(t *testing.T) {
+	ctx := context.Background()
+	server := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: server.Addr()})
+	defer client.Close()
+
+	cfg := config.Default().Cache
+	cfg.RedisURL = "redis://" + server.Addr()
+	cfg.InvalidationChannel = "invalidations"
+	db.InitCaches(cfg)
+	defer db.CloseCaches()
+
+	// Another replica's revocation reaches the local user cache.
+	require.NoError(t, db.UserCache.Set(ctx, "token_hash", schemas.User{Role: "viewer"}, db.DefaultExpiration))
+	require.Eventually(t, func() bool {
+		return client.Publish(ctx, "invalidations:user", `["token_hash"]`).Val() > 0
+	}, time.Second, 10*time.Millisecond)
+	require.Eventually(t, func() bool {
+		var user schemas.User
+		found, err := db.UserCache.Get(ctx, "token_hash", &user)
+		return err == nil && !found
+	}, time.Second, 10*time.Millisecond)
+
+	// Local revocations are published for the other replicas.
+	pubsub := client.Subscribe(ctx, "invalidations:user", "invalidations:role")
+	defer pubsub.Close()
+	_, err := pubsub.Receive(ctx)
+	require.NoError(t, err)
+	require.NoError(t, db.InvalidateUsers(ctx, db.UserCache, "other_hash"))
+	require.NoError(t, db.InvalidateRoles(ctx, db.RoleCache, "editor"))
+	for _, expected := range []string{`["other_hash"]`, `["editor"]`} {
+		message, err := pubsub.ReceiveMessage(ctx)
+		require.NoError(t, err)
+		require.Equal(t, expected, message.Payload)
+	}
+}
+
+func TestSetBannerIfCurrent(t *testing.T) {
+	ctx := context.Background()
+	cache := db.NewMemoryCache(db.MemoryCacheOptions{DefaultExpiration: time.Minute})
+	defer cache.Close()
+	banner := schemas.Banner{FeatureID: 2, TagIDs: []int64{3}, IsActive: true}
+	key := db.BannerCacheKey(3, 2)
+
+	// A lookup that read the database before the write doesn't cache its result after it.
+	generation := db.BannerGeneration(key)
+	require.NoError(t, db.InvalidateBanners(ctx, cache, &banner))
+	cached, err := db.SetBannerIfCurrent(ctx, cache, key, generation, db.BannerCacheEntry{Banner: &banner}, db.DefaultExpiration)
+	require.NoError(t, err)
+	require.False(t, cached)
+	var entry db.BannerCacheEntry
+	found, err := cache.Get(ctx, key, &entry)
+	require.NoError(t, err)
+	require.False(t, found)
+
+	generation = db.BannerGeneration(key)
+	cached, err = db.SetBannerIfCurrent(ctx, cache, key, generation, db.BannerCacheEntry{Banner: &banner}, db.DefaultExpiration)
+	require.NoError(t, err)
+	require.True(t, cached)
+	found, err = cache.Get(ctx, key, &entry)
+	require.NoError(t, err)
+	require.True(t, found)
+}
@@ -0,0 +1,82 @@
+package unit_test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"server/config"
+)
+
+func TestConfigDefaultsAreValid(t *testing.T) {
+	cfg := config.Default()
+	require.NoError(t, cfg.Validate())
+}
+
+func TestConfigFileAndEnvOverrides(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "config.yaml")
+	err := os.WriteFile(file, []byte(`
+server:
+  addr: ":9000"
+database:
+  max_open_conns: 50
+cache:
+  banner:
+    ttl: 1m
+    stale_ttl: 30s
+log:
+  level: debug
+`), 0o600)
+	require.NoError(t, err)
+	t.Setenv("CONFIG_FILE", file)
+	t.Setenv("DB_MAX_IDLE_CONNS", "5")
+	t.Setenv("USER_CACHE_TTL", "2h")
+	t.Setenv("PORT", "8008")
+	t.Setenv("UNREADY_ON_SHUTDOWN", "true")
+
+	cfg, err := config.Load()
+	require.NoError(t, err)
+	require.Equal(t, ":8008", cfg.Server.Addr)
+	require.True(t, cfg.Server.UnreadyOnShutdown)
+	require.Equal(t, 50, cfg.Database.MaxOpenConns)
+	require.Equal(t, 5, cfg.Database.MaxIdleConns)
+	require.Equal(t, time.Minute, cfg.Cache.Banner.TTL)
+	require.Equal(t, 30*time.Second, cfg.Cache.Banner.StaleTTL)
+	require.Equal(t, 30*time.Second, cfg.Cache.Banner.NotFoundTTL)
+	require.Equal(t, 2*time.Hour, cfg.Cache.User.TTL)
+	require.Equal(t, "debug", cfg.Log.Level)
+}
+
+func TestConfigValidationErrors(t *testing.T) {
+	t.Setenv("CACHE_BACKEND", "redis")
+	t.Setenv("AUTH_MODE", "jwt")
+	t.Setenv("GIN_MODE", "verbose")
+	t.Setenv("BANNER_CACHE_MAX_ENTRIES", "many")
+	t.Setenv("TRACING_EXPORTER", "jaeger")
+	t.Setenv("TRACING_SAMPLE_RATIO", "2")
+
+	_, err := config.Load()
+	require.Error(t, err)
+	for _, message := range []string{
+		"invalid BANNER_CACHE_MAX_ENTRIES",
+		"server.gin_mode should be debug, release or test",
+		"cache.redis_url is required for the redis backend",
+		"auth.jwt_hs256_secret or auth.jwt_jwks_file is required",
+		"tracing.exporter should be none, stdout or otlp",
+		"tracing.sample_ratio should be between 0 and 1",
+	} {
+		require.ErrorContains(t, err, message)
+	}
+}
+
+func TestConfigUnknownFileField(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "config.json")
+	require.NoError(t, os.WriteFile(file, []byte(`{"server": {"listen": ":9000"}}`), 0o600))
+	t.Setenv("CONFIG_FILE", file)
+
+	_, err := config.Load()
+	require.Error(t, err)
+}
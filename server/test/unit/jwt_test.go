@@ -0,0 +1,77 @@
+package unit_test
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/stretchr/testify/require"
+
+	"server/middlewares"
+)
+
+func writeJWKS(t *testing.T, kid string, key *rsa.PublicKey) string {
+	t.Helper()
+
+	jwks := map[string]interface{}{
+		"keys": []map[string]string{{
+			"kty": "RSA",
+			"kid": kid,
+			"use": "sig",
+			"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+			"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+		}},
+	}
+	data, err := json.Marshal(jwks)
+	require.NoError(t, err)
+
+	path := filepath.Join(t.TempDir(), "jwks.json")
+	require.NoError(t, os.WriteFile(path, data, 0o600))
+	return path
+}
+
+func TestJWTVerifierRS256(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	otherKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+
+	verifier, err := middlewares.NewJWTVerifier(nil, writeJWKS(t, "main", &key.PublicKey))
+	require.NoError(t, err)
+
+	sign := func(kid string, key *rsa.PrivateKey) string {
+		claims := middlewares.Claims{
+			RegisteredClaims: jwt.RegisteredClaims{ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Hour))},
+			Role:             "admin",
+			Tags:             []int64{1, 2},
+		}
+		token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+		token.Header["kid"] = kid
+		signed, err := token.SignedString(key)
+		require.NoError(t, err)
+		return signed
+	}
+
+	claims, err := verifier.Verify(sign("main", key))
+	require.NoError(t, err)
+	require.Equal(t, "admin", claims.Role)
+	require.Equal(t, []int64{1, 2}, claims.Tags)
+
+	_, err = verifier.Verify(sign("main", otherKey))
+	require.ErrorIs(t, err, jwt.ErrTokenSignatureInvalid)
+
+	_, err = verifier.Verify(sign("other", key))
+	require.ErrorIs(t, err, jwt.ErrTokenUnverifiable)
+
+	hmacToken, err := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{}).SignedString([]byte("secret"))
+	require.NoError(t, err)
+	_, err = verifier.Verify(hmacToken)
+	require.ErrorIs(t, err, jwt.ErrTokenUnverifiable, "HS256 must be rejected without a secret")
+}
@@ -0,0 +1,81 @@
+package unit_test
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"server/db"
+)
+
+func TestMemoryCacheMaxEntries(t *testing.T) {
+	ctx := context.Background()
+	c := db.NewMemoryCache(db.MemoryCacheOptions{DefaultExpiration: time.Minute, MaxEntries: 2})
+	defer c.Close()
+
+	require.NoError(t, c.Set(ctx, "a", 1, db.DefaultExpiration))
+	require.NoError(t, c.Set(ctx, "b", 2, db.DefaultExpiration))
+
+	var value int
+	found, err := c.Get(ctx, "a", &value)
+	require.NoError(t, err)
+	require.True(t, found)
+
+	require.NoError(t, c.Set(ctx, "c", 3, db.DefaultExpiration))
+	found, err = c.Get(ctx, "b", &value)
+	require.NoError(t, err)
+	require.False(t, found, "least recently used entry should be evicted")
+	found, err = c.Get(ctx, "a", &value)
+	require.NoError(t, err)
+	require.True(t, found)
+
+	stats := c.Stats()
+	require.Equal(t, 2, stats.Entries)
+	require.Equal(t, uint64(1), stats.Evictions)
+	require.Equal(t, uint64(2), stats.Hits)
+	require.Equal(t, uint64(1), stats.Misses)
+}
+
+func TestMemoryCacheMaxBytes(t *testing.T) {
+	ctx := context.Background()
+	c := db.NewMemoryCache(db.MemoryCacheOptions{DefaultExpiration: time.Minute, MaxBytes: 100})
+	defer c.Close()
+
+	for i := 0; i < 10; i++ {
+		require.NoError(t, c.Set(ctx, fmt.Sprintf("key%d", i), strings.Repeat("x", 20), db.DefaultExpiration))
+	}
+	stats := c.Stats()
+	require.LessOrEqual(t, stats.Bytes, int64(100))
+	require.Greater(t, stats.Evictions, uint64(0))
+
+	require.NoError(t, c.Set(ctx, "huge", strings.Repeat("x", 200), db.DefaultExpiration))
+	var value string
+	found, err := c.Get(ctx, "huge", &value)
+	require.NoError(t, err)
+	require.False(t, found, "entry larger than the whole budget should not be stored")
+}
+
+func TestMemoryCacheExpiration(t *testing.T) {
+	ctx := context.Background()
+	c := db.NewMemoryCache(db.MemoryCacheOptions{DefaultExpiration: time.Minute, CleanupInterval: 10 * time.Millisecond})
+	defer c.Close()
+
+	require.NoError(t, c.Set(ctx, "short", 1, 20*time.Millisecond))
+	require.NoError(t, c.Set(ctx, "long", 1, db.DefaultExpiration))
+
+	require.Eventually(t, func() bool {
+		return c.Stats().Entries == 1
+	}, time.Second, 10*time.Millisecond)
+
+	var value int
+	found, err := c.Get(ctx, "short", &value)
+	require.NoError(t, err)
+	require.False(t, found)
+	found, err = c.Get(ctx, "long", &value)
+	require.NoError(t, err)
+	require.True(t, found)
+}
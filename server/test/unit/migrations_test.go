@@ -0,0 +1,26 @@
+package unit_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"server/db"
+)
+
+func TestMigrationsAreEmbeddedInSequence(t *testing.T) {
+	migrations, err := db.Migrations()
+	require.NoError(t, err)
+	require.NotEmpty(t, migrations)
+
+	for i, migration := range migrations {
+		require.Equal(t, i+1, migration.Version)
+		require.NotEmpty(t, migration.Name)
+		require.NotEmpty(t, migration.Up)
+		require.NotEmpty(t, migration.Down)
+	}
+
+	latest, err := db.LatestSchemaVersion()
+	require.NoError(t, err)
+	require.Equal(t, len(migrations), latest)
+}
@@ -0,0 +1,92 @@
+package unit_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"server/repository"
+	"server/schemas"
+	"server/seed"
+)
+
+const seedYAML = `
+users:
+  - token: seeded_token
+    role: editor
+    tags: [7, 8]
+banners:
+  - feature_id: 5
+    tag_ids: [7]
+    content: {title: seeded, priority: 1}
+    is_active: true
+`
+
+func TestSeedApplyIsIdempotent(t *testing.T) {
+	ctx := context.Background()
+	users := repository.NewMemoryUserRepository()
+	banners := repository.NewMemoryBannerRepository()
+
+	data, err := seed.Parse([]byte(seedYAML))
+	require.NoError(t, err)
+	require.NoError(t, seed.Apply(ctx, data, users, banners))
+	require.NoError(t, seed.Apply(ctx, data, users, banners))
+
+	all, err := users.List(ctx, 0, 0)
+	require.NoError(t, err)
+	require.Len(t, all, 1)
+	require.Equal(t, "editor", all[0].Role)
+	require.Equal(t, []int64{7, 8}, []int64(all[0].TagIDs))
+
+	banner, err := banners.FindForUser(ctx, 7, 5)
+	require.NoError(t, err)
+	require.Equal(t, 1, banner.Version)
+
+	data.Users[0].Role = "viewer"
+	data.Banners[0].Content["title"] = "changed"
+	require.NoError(t, seed.Apply(ctx, data, users, banners))
+
+	user, err := users.FindByTokenHash(ctx, schemas.HashToken("seeded_token"))
+	require.NoError(t, err)
+	require.Equal(t, "viewer", user.Role)
+	banner, err = banners.FindForUser(ctx, 7, 5)
+	require.NoError(t, err)
+	require.Equal(t, "changed", banner.Content["title"])
+	require.Equal(t, 2, banner.Version)
+}
+
+func TestSeedParse(t *testing.T) {
+	data, err := seed.Parse([]byte(`{"users": [{"token": "json_token", "role": "admin"}]}`))
+	require.NoError(t, err)
+	require.Equal(t, "json_token", data.Users[0].Token)
+
+	_, err = seed.Parse([]byte(`{"users": [{"role": "admin"}]}`))
+	require.Error(t, err)
+	_, err = seed.Parse([]byte(`{"banners": [{"feature_id": 1}]}`))
+	require.Error(t, err)
+	_, err = seed.Parse([]byte(`{"unknown": true}`))
+	require.Error(t, err)
+}
+
+func TestSeedEnabled(t *testing.T) {
+	for _, test := range []struct {
+		env      string
+		override string
+		expected bool
+	}{
+		{env: "", expected: false},
+		{env: "production", expected: false},
+		{env: "dev", expected: true},
+		{env: "test", expected: true},
+		{env: "production", override: "true", expected: true},
+		{env: "dev", override: "false", expected: false},
+	} {
+		t.Setenv("APP_ENV", test.env)
+		t.Setenv("SEED_ENABLED", test.override)
+
+		enabled, err := seed.Enabled()
+		require.NoError(t, err)
+		require.Equal(t, test.expected, enabled, test)
+	}
+}
@@ -0,0 +1,116 @@
+package unit_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"server/db"
+	"server/jobs"
+	"server/repository"
+	"server/schemas"
+)
+
+func TestWorkerStop(t *testing.T) {
+	ctx := context.Background()
+	jobRepository := repository.NewMemoryJobRepository()
+	banners := repository.NewMemoryBannerRepository()
+	cache := db.NewMemoryCache(db.MemoryCacheOptions{DefaultExpiration: time.Minute})
+	defer cache.Close()
+
+	banner := schemas.Banner{FeatureID: 1, TagIDs: []int64{1}, IsActive: true}
+	require.NoError(t, banners.Create(ctx, &banner))
+
+	worker := jobs.NewWorker(jobRepository, banners, cache)
+	worker.Start()
+	job, err := worker.EnqueueBannerDeletion(ctx, 1, 0)
+	require.NoError(t, err)
+	require.Eventually(t, func() bool {
+		stored, err := jobRepository.FindByID(ctx, job.ID)
+		require.NoError(t, err)
+		return stored.Status == schemas.JobDone
+	}, 5*time.Second, 10*time.Millisecond)
+
+	stopCtx, cancel := context.WithTimeout(ctx, time.Second)
+	defer cancel()
+	require.NoError(t, worker.Stop(stopCtx))
+	require.NoError(t, worker.Stop(stopCtx))
+}
+
+func TestWorkerStopWithoutStart(t *testing.T) {
+	worker := jobs.NewWorker(repository.NewMemoryJobRepository(), repository.NewMemoryBannerRepository(), nil)
+	require.NoError(t, worker.Stop(context.Background()))
+}
+
+func TestJobClaim(t *testing.T) {
+	ctx := context.Background()
+	jobRepository := repository.NewMemoryJobRepository()
+	for _, status := range []schemas.JobStatus{schemas.JobDone, schemas.JobPending, schemas.JobPending} {
+		require.NoError(t, jobRepository.Create(ctx, &schemas.BannerDeletionJob{Status: status}))
+	}
+
+	// Pending jobs are claimed oldest first, each one once.
+	leaseUntil := time.Now().Add(time.Minute)
+	first, err := jobRepository.Claim(ctx, leaseUntil)
+	require.NoError(t, err)
+	require.Equal(t, uint(2), first.ID)
+	require.Equal(t, schemas.JobRunning, first.Status)
+	second, err := jobRepository.Claim(ctx, leaseUntil)
+	require.NoError(t, err)
+	require.Equal(t, uint(3), second.ID)
+	_, err = jobRepository.Claim(ctx, leaseUntil)
+	require.ErrorIs(t, err, repository.ErrNotFound)
+
+	// A running job is taken over once its lease expires.
+	expired := time.Now().Add(-time.Second)
+	first.LeaseUntil = &expired
+	require.NoError(t, jobRepository.Save(ctx, first))
+	claimed, err := jobRepository.Claim(ctx, leaseUntil)
+	require.NoError(t, err)
+	require.Equal(t, first.ID, claimed.ID)
+	_, err = jobRepository.Claim(ctx, leaseUntil)
+	require.ErrorIs(t, err, repository.ErrNotFound)
+
+	// Only the worker holding the current lease may save the job.
+	first.Deleted = 10
+	require.ErrorIs(t, jobRepository.SaveClaimed(ctx, first, &leaseUntil), repository.ErrClaimLost)
+	claimed.Deleted = 1
+	renewed := leaseUntil.Add(time.Minute)
+	require.NoError(t, jobRepository.SaveClaimed(ctx, claimed, &renewed))
+	stored, err := jobRepository.FindByID(ctx, claimed.ID)
+	require.NoError(t, err)
+	require.Equal(t, 1, stored.Deleted)
+	require.True(t, renewed.Equal(*stored.LeaseUntil))
+}
+
+func TestWorkerPicksUpJobsEnqueuedBeforeStart(t *testing.T) {
+	ctx := context.Background()
+	jobRepository := repository.NewMemoryJobRepository()
+	banners := repository.NewMemoryBannerRepository()
+	cache := db.NewMemoryCache(db.MemoryCacheOptions{DefaultExpiration: time.Minute})
+	defer cache.Close()
+
+	// Enqueueing doesn't wait for the worker however many jobs are pending.
+	worker := jobs.NewWorker(jobRepository, banners, cache)
+	var enqueued []uint
+	for i := 0; i < 2000; i++ {
+		job, err := worker.EnqueueBannerDeletion(ctx, i+1, 0)
+		require.NoError(t, err)
+		enqueued = append(enqueued, job.ID)
+	}
+
+	worker.Start()
+	defer worker.Stop(ctx)
+	require.Eventually(t, func() bool {
+		for _, id := range enqueued {
+			stored, err := jobRepository.FindByID(ctx, id)
+			require.NoError(t, err)
+			if stored.Status != schemas.JobDone {
+				return false
+			}
+		}
+		return true
+	}, 5*time.Second, 10*time.Millisecond)
+}
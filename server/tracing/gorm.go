@@ -0,0 +1,72 @@
+package tracing
+
+import (
+	"errors"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	semconv "go.opentelemetry.io/otel/semconv/v1.24.0"
+	"go.opentelemetry.io/otel/trace"
+	"gorm.io/gorm"
+)
+
+const spanKey = "tracing:span"
+
+// InstrumentDB makes every query through gdb a span, a child of the span in the
+// context the query was made with.
+func InstrumentDB(gdb *gorm.DB) error {
+	return gdb.Use(gormPlugin{})
+}
+
+type gormPlugin struct{}
+
+func (gormPlugin) Name() string {
+	return "tracing"
+}
+
+func (gormPlugin) Initialize(gdb *gorm.DB) error {
+	callbacks := gdb.Callback()
+	return errors.Join(
+		callbacks.Create().Before("*").Register("tracing:before_create", startSpan("create")),
+		callbacks.Create().After("*").Register("tracing:after_create", endSpan),
+		callbacks.Query().Before("*").Register("tracing:before_query", startSpan("query")),
+		callbacks.Query().After("*").Register("tracing:after_query", endSpan),
+		callbacks.Update().Before("*").Register("tracing:before_update", startSpan("update")),
+		callbacks.Update().After("*").Register("tracing:after_update", endSpan),
+		callbacks.Delete().Before("*").Register("tracing:before_delete", startSpan("delete")),
+		callbacks.Delete().After("*").Register("tracing:after_delete", endSpan),
+		callbacks.Row().Before("*").Register("tracing:before_row", startSpan("row")),
+		callbacks.Row().After("*").Register("tracing:after_row", endSpan),
+		callbacks.Raw().Before("*").Register("tracing:before_raw", startSpan("raw")),
+		callbacks.Raw().After("*").Register("tracing:after_raw", endSpan),
+	)
+}
+
+func startSpan(operation string) func(tx *gorm.DB) {
+	return func(tx *gorm.DB) {
+		ctx, span := Tracer().Start(tx.Statement.Context, "gorm."+operation,
+			trace.WithSpanKind(trace.SpanKindClient),
+			trace.WithAttributes(semconv.DBSystemPostgreSQL, semconv.DBOperation(operation)))
+		tx.Statement.Context = ctx
+		tx.InstanceSet(spanKey, span)
+	}
+}
+
+func endSpan(tx *gorm.DB) {
+	value, ok := tx.InstanceGet(spanKey)
+	if !ok {
+		return
+	}
+	span := value.(trace.Span)
+	defer span.End()
+
+	span.SetAttributes(
+		semconv.DBSQLTable(tx.Statement.Table),
+		semconv.DBStatement(tx.Statement.SQL.String()),
+		attribute.Int64("db.rows_affected", tx.RowsAffected),
+	)
+	if tx.Error != nil && !errors.Is(tx.Error, gorm.ErrRecordNotFound) {
+		span.RecordError(tx.Error)
+		span.SetStatus(codes.Error, tx.Error.Error())
+	}
+}